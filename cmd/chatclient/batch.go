@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gochat/common"
+	"gochat/common/message/enum"
+	"gochat/goclient"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchEvent JSON输出时每行一个事件
+type batchEvent struct {
+	Type      string          `json:"type"`
+	Time      time.Time       `json:"time"`
+	Line      int             `json:"line,omitempty"`
+	Command   string          `json:"command,omitempty"`
+	Code      string          `json:"code,omitempty"`
+	RequestID int64           `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Elapsed   string          `json:"elapsed,omitempty"`
+	OK        *bool           `json:"ok,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// batchRunner 按行执行脚本，除普通命令外支持:
+//
+//	# 注释
+//	wait <code> [timeout] [text]   等待下一条该消息码的消息，text非空时要求消息内容包含text
+//	sleep <duration>
+//	exit                           提前结束脚本
+//
+// wait只匹配上一次wait之后收到的消息，所以命令的回复先于wait到达也不会丢失。
+// 收到服务端的错误回复、命令执行失败、等待超时或连接断开都会使脚本失败。
+type batchRunner struct {
+	*commandDispatcher
+	client  *goclient.Client
+	lines   []string
+	timeout time.Duration
+	json    bool
+	out     io.Writer
+	outLock sync.Mutex
+
+	lock     sync.Mutex
+	pending  []*common.RawMessage
+	notify   chan struct{}
+	requests map[int64]string
+	failure  error
+
+	done     chan struct{}
+	exitCode int
+}
+
+func NewBatchRunner(client *goclient.Client, script string, timeout time.Duration, jsonOutput bool) (*batchRunner, error) {
+	var reader io.Reader = os.Stdin
+	if script != "-" {
+		file, err := os.Open(script)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read script error: %w", err)
+	}
+	return &batchRunner{
+		commandDispatcher: NewCommandDispatcher(client, NewScannerReader(strings.NewReader(""))),
+		client:            client,
+		lines:             lines,
+		timeout:           timeout,
+		json:              jsonOutput,
+		out:               os.Stdout,
+		notify:            make(chan struct{}, 1),
+		requests:          make(map[int64]string),
+		done:              make(chan struct{}),
+	}, nil
+}
+
+// Track 记录请求的来源，用于描述失败原因
+func (r *batchRunner) Track(requestID int64, name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests[requestID] = name
+}
+
+// Observe 作为goclient.Observer注册到客户端
+func (r *batchRunner) Observe(message *common.RawMessage) {
+	if message.Code == enum.Ping || message.Code == enum.Pong {
+		return
+	}
+	r.lock.Lock()
+	r.pending = append(r.pending, message)
+	if message.Code == common.ErrorMessageCode && r.failure == nil {
+		errMsg := &common.ErrorMessage{}
+		if err := json.Unmarshal(message.RawData, errMsg); err == nil {
+			name, ok := r.requests[errMsg.RequestID]
+			if !ok {
+				name = fmt.Sprintf("request %d", errMsg.RequestID)
+			}
+			r.failure = fmt.Errorf("%s failed: code=%d, message=%s", name, errMsg.Code, errMsg.Message)
+		}
+	}
+	r.lock.Unlock()
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	r.emit(batchEvent{
+		Type:      "message",
+		Code:      message.Code.String(),
+		RequestID: message.RequestID,
+		Data:      jsonData(message.RawData),
+	})
+}
+
+func (r *batchRunner) Dispatch() {
+	err := r.run()
+	if !r.client.Flush(r.timeout) && err == nil {
+		err = errors.New("flush pending messages timeout")
+	}
+	ok := err == nil
+	event := batchEvent{Type: "result", OK: &ok}
+	if err != nil {
+		r.exitCode = 1
+		event.Error = err.Error()
+		r.logger.Error("batch failed", common.Err(err))
+	} else {
+		r.logger.Info("batch finished")
+	}
+	r.emit(event)
+	close(r.done)
+	_ = r.client.Close()
+}
+
+// Wait 等待脚本结束，返回进程退出码
+func (r *batchRunner) Wait() int {
+	<-r.done
+	return r.exitCode
+}
+
+func (r *batchRunner) run() error {
+	for i, line := range r.lines {
+		number := i + 1
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := r.asyncFailure(); err != nil {
+			return err
+		}
+		fields, err := goclient.Tokenize(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "exit" {
+			return nil
+		}
+		r.emit(batchEvent{Type: "command", Line: number, Command: line})
+		if err := r.step(number, line, fields); err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+	}
+	return r.asyncFailure()
+}
+
+func (r *batchRunner) step(number int, line string, fields []string) error {
+	switch fields[0] {
+	case "wait":
+		return r.wait(number, fields[1:])
+	case "sleep":
+		if len(fields) != 2 {
+			return errors.New("use like: sleep <duration>")
+		}
+		duration, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(duration):
+			return nil
+		case <-r.client.Done():
+			return errors.New("connection closed")
+		}
+	}
+	_, requestID, err := r.execute(line)
+	if err != nil {
+		return err
+	}
+	if requestID != 0 {
+		r.Track(requestID, fmt.Sprintf("line %d", number))
+		r.emit(batchEvent{Type: "request", Line: number, RequestID: requestID})
+	}
+	return nil
+}
+
+func (r *batchRunner) wait(number int, args []string) error {
+	if len(args) == 0 {
+		return errors.New("use like: wait <code> [timeout] [text]")
+	}
+	code, err := parseCode(args[0])
+	if err != nil {
+		return err
+	}
+	timeout := r.timeout
+	if len(args) > 1 {
+		if d, err := time.ParseDuration(args[1]); err == nil {
+			timeout = d
+			args = args[1:]
+		}
+	}
+	text := strings.Join(args[1:], " ")
+	start := time.Now()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if message := r.take(code, text); message != nil {
+			ok := true
+			r.emit(batchEvent{Type: "wait", Line: number, Code: code.String(), RequestID: message.RequestID,
+				Elapsed: time.Since(start).String(), OK: &ok})
+			return nil
+		}
+		select {
+		case <-r.notify:
+		case <-timer.C:
+			return fmt.Errorf("wait %s timeout after %s", code, timeout)
+		case <-r.client.Done():
+			return errors.New("connection closed")
+		}
+	}
+}
+
+// take 取出第一条匹配的消息，丢弃其之前的消息
+func (r *batchRunner) take(code common.MessageCode, text string) *common.RawMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, message := range r.pending {
+		if message.Code != code || !strings.Contains(messageText(message.RawData), text) {
+			continue
+		}
+		r.pending = r.pending[i+1:]
+		return message
+	}
+	return nil
+}
+
+func (r *batchRunner) asyncFailure() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.failure
+}
+
+func (r *batchRunner) emit(event batchEvent) {
+	if !r.json {
+		return
+	}
+	event.Time = time.Now()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	r.outLock.Lock()
+	defer r.outLock.Unlock()
+	_, _ = r.out.Write(append(data, '\n'))
+}
+
+// parseCode 支持注册的消息名或数字
+func parseCode(s string) (common.MessageCode, error) {
+	if info, ok := common.LookupCodeByName(s); ok {
+		return info.Code, nil
+	}
+	code, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown message code %q", s)
+	}
+	return common.MessageCode(code), nil
+}
+
+// messageText 消息体为JSON字符串时返回解码后的字符串
+func messageText(data []byte) string {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return text
+	}
+	return string(data)
+}
+
+func jsonData(data []byte) json.RawMessage {
+	if len(data) == 0 || !json.Valid(data) {
+		return nil
+	}
+	return json.RawMessage(data)
+}
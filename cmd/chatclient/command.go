@@ -1,25 +1,221 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"gochat/common"
 	"gochat/common/message/enum"
 	"gochat/common/message/msg"
 	"gochat/goclient"
+	"io"
+	"sort"
+	"strings"
+	"sync"
 )
 
+// chatModule 聊天相关的命令，同时维护在线用户列表供补全使用，聊天内容写到out
+type chatModule struct {
+	common.BaseModule
+	users *userDirectory
+	out   io.Writer
+}
+
+func NewChatModule(client *goclient.Client, out io.Writer) *chatModule {
+	return &chatModule{out: out, users: &userDirectory{client: client, out: out, users: make(map[string]string)}}
+}
+
+// display 输出给用户看的内容，和诊断日志分开，不受日志级别和格式影响
+func display(out io.Writer, text string) error {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, err := io.WriteString(out, text)
+	return err
+}
+
+func (m *chatModule) Name() string {
+	return "chat"
+}
+
+func (m *chatModule) Handlers() map[common.MessageCode]common.Handler {
+	return map[common.MessageCode]common.Handler{
+		enum.OnlineUserList: m.users,
+		enum.UserPresence:   m.users,
+		enum.PrivateMessage: common.NewTypedHandler(m.displayPrivateMessage),
+		enum.RoomMessage:    common.NewTypedHandler(m.displayRoomMessage),
+		enum.RoomList:       common.NewTypedHandler(m.displayRoomList),
+		enum.RoomMembers:    common.NewTypedHandler(m.displayRoomMembers),
+		enum.History:        common.NewTypedHandler(m.displayHistory),
+		enum.Inbox:          common.NewTypedHandler(m.displayInbox),
+	}
+}
+
+// displayPrivateMessage 收到的私聊和自己发出的回显使用同一种格式
+func (m *chatModule) displayPrivateMessage(_ common.Context, message *msg.PrivateMsg) error {
+	return display(m.out, fmt.Sprintf("[private] %s(%s) -> %s(%s)\n\t%s",
+		message.FromNickName, shortID(message.From), message.ToNickName, shortID(message.To), message.Text))
+}
+
+// Users 已知的在线用户
+func (m *chatModule) Users() []msg.OnlineUserInfo {
+	return m.users.list()
+}
+
+// userDirectory 根据userlist的回复和上下线事件维护在线用户，
+// 第一次收到上下线事件时静默拉取一次完整列表
+type userDirectory struct {
+	common.BaseHandler
+	client *goclient.Client
+	out    io.Writer
+	lock   sync.Mutex
+	users  map[string]string
+	loaded bool
+	silent int64
+}
+
+func (d *userDirectory) OnMessage(ctx common.Context, message *common.RawMessage) error {
+	switch message.Code {
+	case enum.OnlineUserList:
+		list := &msg.OnlineUserListMsg{}
+		if err := json.Unmarshal(message.RawData, list); err != nil {
+			return err
+		}
+		d.lock.Lock()
+		d.users = make(map[string]string, len(list.Users))
+		for _, user := range list.Users {
+			d.users[user.ID] = user.NickName
+		}
+		d.loaded = true
+		silent := message.RequestID != 0 && message.RequestID == d.silent
+		d.lock.Unlock()
+		if !silent {
+			return display(d.out, formatUserList(list.Users))
+		}
+	case enum.UserPresence:
+		presence := &msg.UserPresenceMsg{}
+		if err := json.Unmarshal(message.RawData, presence); err != nil {
+			return err
+		}
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if presence.Online {
+			d.users[presence.ID] = presence.NickName
+		} else {
+			delete(d.users, presence.ID)
+		}
+		if !d.loaded && d.silent == 0 {
+			d.silent = d.client.SendMessage(&common.Message{Code: enum.GetOnlineUserList})
+		}
+	}
+	return nil
+}
+
+func (d *userDirectory) list() []msg.OnlineUserInfo {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	users := make([]msg.OnlineUserInfo, 0, len(d.users))
+	for id, nickName := range d.users {
+		users = append(users, msg.OnlineUserInfo{ID: id, NickName: nickName})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].NickName < users[j].NickName
+	})
+	return users
+}
+
+func formatUserList(users []msg.OnlineUserInfo) string {
+	sb := &strings.Builder{}
+	sb.WriteString(fmt.Sprintf("online user number: %d\n", len(users)))
+	for _, user := range users {
+		sb.WriteString(fmt.Sprintf("ID=%s, nickname=%s\n", user.ID, user.NickName))
+	}
+	return sb.String()
+}
+
+func (m *chatModule) Commands() []*goclient.Command {
+	return []*goclient.Command{NewLoginCommand(), NewRegisterCommand(), NewLogoutCommand(), NewGetUserListCommand(), NewSendCommand(), NewPrivateMessageCommand()}
+}
+
 func NewSendCommand() *goclient.Command {
 	return &goclient.Command{
 		Command: "send",
 		Alias:   nil,
-		ParseFunc: func(params string) (*common.Message, error) {
+		Args:    []goclient.Arg{{Name: "message", Variadic: true, Help: "quote to keep repeated spaces"}},
+		ParseFunc: func(args *goclient.Args) (*common.Message, error) {
 			return &common.Message{
 				Code:    enum.SendMessage,
-				RawData: params,
+				RawData: args.String("message"),
 			}, nil
 		},
 		UseParseFunc:   true,
 		LocalParseFunc: nil,
-		Tips:           "send message to everyone",
+		Tips:           "send message to the lobby",
+	}
+}
+
+func (m *chatModule) displayRoomMessage(_ common.Context, message *msg.RoomMsg) error {
+	return display(m.out, fmt.Sprintf("[#%s] %s(%s)\n\t%s",
+		message.Room, message.FromNickName, shortID(message.From), message.Text))
+}
+
+func (m *chatModule) displayRoomList(_ common.Context, list *msg.RoomListMsg) error {
+	sb := &strings.Builder{}
+	sb.WriteString(fmt.Sprintf("room number: %d\n", len(list.Rooms)))
+	for _, room := range list.Rooms {
+		sb.WriteString(fmt.Sprintf("%s, members=%d", room.Name, room.Members))
+		if room.InviteOnly {
+			sb.WriteString(", invite-only")
+		}
+		if room.Joined {
+			sb.WriteString(", joined")
+		}
+		sb.WriteString("\n")
+	}
+	return display(m.out, sb.String())
+}
+
+func (m *chatModule) displayRoomMembers(_ common.Context, members *msg.RoomMembersMsg) error {
+	return display(m.out, fmt.Sprintf("room %s, ", members.Room)+formatUserList(members.Members))
+}
+
+// displayHistory 时间为服务端时间，按本地时区显示
+func (m *chatModule) displayHistory(_ common.Context, history *msg.HistoryMsg) error {
+	sb := &strings.Builder{}
+	sb.WriteString(fmt.Sprintf("history of #%s, %d messages\n", history.Room, len(history.Messages)))
+	for _, entry := range history.Messages {
+		sb.WriteString(fmt.Sprintf("[%s] %s(%s): %s\n", entry.Time.Local().Format("01-02 15:04:05"),
+			entry.FromNickName, shortID(entry.From), entry.Text))
+	}
+	return display(m.out, sb.String())
+}
+
+func (m *chatModule) displayInbox(_ common.Context, inbox *msg.InboxMsg) error {
+	sb := &strings.Builder{}
+	sb.WriteString(fmt.Sprintf("you have %d unread messages\n", inbox.Unread))
+	for _, entry := range inbox.Messages {
+		sb.WriteString(fmt.Sprintf("[%s] %s(%s): %s\n", entry.Time.Local().Format("01-02 15:04:05"),
+			entry.FromNickName, shortID(entry.From), entry.Text))
+	}
+	return display(m.out, sb.String())
+}
+
+func NewPrivateMessageCommand() *goclient.Command {
+	return &goclient.Command{
+		Command: "msg",
+		Alias:   nil,
+		Args: []goclient.Arg{
+			{Name: "to", Help: "user ID or nickname", Complete: goclient.CompleteUser},
+			{Name: "text", Variadic: true},
+		},
+		ParseFunc: func(args *goclient.Args) (*common.Message, error) {
+			return &common.Message{
+				Code:    enum.PrivateMessage,
+				RawData: &msg.PrivateMsg{To: args.String("to"), Text: args.String("text")},
+			}, nil
+		},
+		UseParseFunc:   true,
+		LocalParseFunc: nil,
+		Tips:           "send private message to one user",
 	}
 }
 
@@ -27,15 +223,36 @@ func NewLoginCommand() *goclient.Command {
 	return &goclient.Command{
 		Command: "login",
 		Alias:   nil,
-		ParseFunc: func(params string) (*common.Message, error) {
+		Args: []goclient.Arg{
+			{Name: "nickname"},
+			{Name: "password", Optional: true, Secret: true, Help: "required for registered nicknames"},
+		},
+		ParseFunc: func(args *goclient.Args) (*common.Message, error) {
 			return &common.Message{
 				Code:    enum.UserLogin,
-				RawData: &msg.LoginMsg{NickName: params},
+				RawData: &msg.LoginMsg{NickName: args.String("nickname"), Password: args.String("password")},
+			}, nil
+		},
+		UseParseFunc:   true,
+		LocalParseFunc: nil,
+		Tips:           "login with a nickname, registered nicknames need the password",
+	}
+}
+
+func NewRegisterCommand() *goclient.Command {
+	return &goclient.Command{
+		Command: "register",
+		Alias:   nil,
+		Args:    []goclient.Arg{{Name: "nickname"}, {Name: "password", Secret: true}},
+		ParseFunc: func(args *goclient.Args) (*common.Message, error) {
+			return &common.Message{
+				Code:    enum.UserRegister,
+				RawData: &msg.RegisterMsg{NickName: args.String("nickname"), Password: args.String("password")},
 			}, nil
 		},
 		UseParseFunc:   true,
 		LocalParseFunc: nil,
-		Tips:           "use like login [nickname]",
+		Tips:           "register a nickname so only you can login with it",
 	}
 }
 
@@ -43,7 +260,7 @@ func NewLogoutCommand() *goclient.Command {
 	return &goclient.Command{
 		Command: "logout",
 		Alias:   nil,
-		ParseFunc: func(params string) (*common.Message, error) {
+		ParseFunc: func(_ *goclient.Args) (*common.Message, error) {
 			return &common.Message{
 				Code:    enum.UserLogout,
 				RawData: nil,
@@ -51,7 +268,7 @@ func NewLogoutCommand() *goclient.Command {
 		},
 		UseParseFunc:   true,
 		LocalParseFunc: nil,
-		Tips:           "use like logout",
+		Tips:           "logout but keep the connection",
 	}
 }
 
@@ -59,7 +276,7 @@ func NewGetUserListCommand() *goclient.Command {
 	return &goclient.Command{
 		Command: "userlist",
 		Alias:   nil,
-		ParseFunc: func(params string) (*common.Message, error) {
+		ParseFunc: func(_ *goclient.Args) (*common.Message, error) {
 			return &common.Message{
 				Code:    enum.GetOnlineUserList,
 				RawData: nil,
@@ -67,6 +284,65 @@ func NewGetUserListCommand() *goclient.Command {
 		},
 		UseParseFunc:   true,
 		LocalParseFunc: nil,
-		Tips:           "use like userlist",
+		Tips:           "show online users",
+	}
+}
+
+// protocolModule 查询并显示服务端支持的消息码
+type protocolModule struct {
+	common.BaseModule
+	out io.Writer
+}
+
+func (m *protocolModule) Name() string {
+	return "protocol"
+}
+
+func (m *protocolModule) Handlers() map[common.MessageCode]common.Handler {
+	return map[common.MessageCode]common.Handler{
+		enum.DescribeProtocol: common.NewTypedHandler(m.displayProtocol),
+	}
+}
+
+func (m *protocolModule) Commands() []*goclient.Command {
+	return []*goclient.Command{NewDescribeProtocolCommand()}
+}
+
+func NewDescribeProtocolCommand() *goclient.Command {
+	return &goclient.Command{
+		Command: "protocol",
+		Alias:   nil,
+		ParseFunc: func(_ *goclient.Args) (*common.Message, error) {
+			return &common.Message{
+				Code:    enum.DescribeProtocol,
+				RawData: nil,
+			}, nil
+		},
+		UseParseFunc:   true,
+		LocalParseFunc: nil,
+		Tips:           "show message codes supported by server",
+	}
+}
+
+func (m *protocolModule) displayProtocol(_ common.Context, schemas []common.CodeSchema) error {
+	sb := &strings.Builder{}
+	sb.WriteString("server protocol:\n")
+	for _, schema := range schemas {
+		sb.WriteString(fmt.Sprintf("%d %s [%s]", schema.Code, schema.Name, schema.Direction))
+		if schema.Payload != "" {
+			sb.WriteString(" payload=" + schema.Payload)
+		}
+		if schema.Auth {
+			sb.WriteString(" auth")
+		}
+		sb.WriteString("\n")
+		for _, field := range schema.Fields {
+			sb.WriteString(fmt.Sprintf("\t%s %s", field.Name, field.Type))
+			if field.Required {
+				sb.WriteString(" required")
+			}
+			sb.WriteString("\n")
+		}
 	}
+	return display(m.out, sb.String())
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"gochat/common/message/msg"
+	"gochat/goclient"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// completer 交互输入的Tab补全，第一个词补全命令名，之后按参数声明补全
+type completer struct {
+	dispatcher *commandDispatcher
+	users      func() []msg.OnlineUserInfo
+}
+
+func NewCompleter(dispatcher *commandDispatcher, users func() []msg.OnlineUserInfo) *completer {
+	return &completer{dispatcher: dispatcher, users: users}
+}
+
+// Complete 实现terminal.Completer，候选项已按命令行的规则转义
+func (c *completer) Complete(line []rune, pos int) (int, []string) {
+	start := wordStart(line, pos)
+	words, err := goclient.Tokenize(string(line[:start]))
+	if err != nil {
+		return 0, nil
+	}
+	prefix := unescape(string(line[start:pos]))
+	var candidates []string
+	if len(words) == 0 {
+		return start, escapeAll(filterPrefix(c.dispatcher.names(), prefix))
+	}
+	command, ok := c.dispatcher.lookup(words[0])
+	if !ok {
+		return 0, nil
+	}
+	if strings.HasPrefix(prefix, "-") {
+		for _, flag := range command.Flags {
+			candidates = append(candidates, "-"+flag.Name)
+		}
+		return start, filterPrefix(candidates, prefix)
+	}
+	arg, ok := currentArg(command, words[1:])
+	if !ok {
+		return 0, nil
+	}
+	switch arg.Complete {
+	case goclient.CompleteCommand:
+		for _, command := range c.dispatcher.commands() {
+			candidates = append(candidates, command.Command)
+		}
+	case goclient.CompleteUser:
+		if c.users != nil {
+			for _, user := range c.users() {
+				candidates = append(candidates, user.ID, user.NickName)
+			}
+		}
+	case goclient.CompleteFile:
+		candidates = completeFile(prefix)
+	}
+	return start, escapeAll(filterPrefix(candidates, prefix))
+}
+
+// currentArg 根据已输入的参数确定正在输入的是哪个位置参数
+func currentArg(command *goclient.Command, words []string) (goclient.Arg, bool) {
+	index := 0
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if word == "--" {
+			index += len(words) - i - 1
+			break
+		}
+		if len(word) > 1 && word[0] == '-' && !strings.Contains(word, "=") {
+			for _, flag := range command.Flags {
+				if flag.Name == strings.TrimLeft(word, "-") && flag.Type != goclient.ArgBool {
+					i++
+				}
+			}
+			continue
+		}
+		if len(word) > 1 && word[0] == '-' {
+			continue
+		}
+		index++
+	}
+	if len(command.Args) == 0 {
+		return goclient.Arg{}, false
+	}
+	if index >= len(command.Args) {
+		last := command.Args[len(command.Args)-1]
+		return last, last.Variadic
+	}
+	return command.Args[index], true
+}
+
+func completeFile(prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	readDir := dir
+	if len(readDir) == 0 {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		candidates = append(candidates, dir+name)
+	}
+	return candidates
+}
+
+// wordStart 光标所在词的起点，反斜杠转义的空格不算分隔
+func wordStart(line []rune, pos int) int {
+	for i := pos; i > 0; i-- {
+		if line[i-1] == ' ' && (i < 2 || line[i-2] != '\\') {
+			return i
+		}
+	}
+	return 0
+}
+
+func filterPrefix(candidates []string, prefix string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) && !seen[candidate] {
+			seen[candidate] = true
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
+
+func unescape(word string) string {
+	sb := &strings.Builder{}
+	escaped := false
+	for _, r := range word {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func escapeAll(candidates []string) []string {
+	for i, candidate := range candidates {
+		sb := &strings.Builder{}
+		for _, r := range candidate {
+			if r == ' ' || r == '\\' || r == '"' || r == '\'' || r == '\t' {
+				sb.WriteRune('\\')
+			}
+			sb.WriteRune(r)
+		}
+		candidates[i] = sb.String()
+	}
+	return candidates
+}
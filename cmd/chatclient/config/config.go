@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EnvConfigFile 未指定-config时从该环境变量读取配置文件路径
+const EnvConfigFile = "GOCHAT_CLIENT_CONFIG"
+
+type TLSConfig struct {
+	Enabled bool `json:"enabled"`
+	// CAFile 为空时使用系统根证书
+	CAFile string `json:"ca_file"`
+	// CertFile和KeyFile 服务端要求客户端证书时使用
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// Load 未启用TLS时返回nil
+func (c TLSConfig) Load(address string) (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if len(config.ServerName) == 0 {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	if len(c.CAFile) != 0 {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca error: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if len(c.CertFile) != 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls key pair error: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Profile 一个服务器及在其上使用的身份
+type Profile struct {
+	Address  string `json:"address"`
+	NickName string `json:"nickname"`
+	// Token 握手时发送给服务端的认证令牌
+	Token string `json:"token"`
+	// PasswordEnv 自动登录时从该环境变量读取密码，注册用户需要设置，密码不写入配置文件
+	PasswordEnv string    `json:"password_env"`
+	DownloadDir string    `json:"download_dir"`
+	AutoLogin   bool      `json:"auto_login"`
+	TLS         TLSConfig `json:"tls"`
+}
+
+// Password 自动登录使用的密码，未设置PasswordEnv时为空，以访客身份登录
+func (p *Profile) Password() string {
+	if len(p.PasswordEnv) == 0 {
+		return ""
+	}
+	return os.Getenv(p.PasswordEnv)
+}
+
+type File struct {
+	DefaultProfile string              `json:"default_profile"`
+	Profiles       map[string]*Profile `json:"profiles"`
+}
+
+type Options struct {
+	ConfigFile  string
+	ProfileName string
+	Profile     *Profile
+	LogLevel    string
+	LogFormat   string
+	// Script 非空时以批处理模式执行该文件中的命令，"-"表示标准输入
+	Script      string
+	JSONOutput  bool
+	WaitTimeout time.Duration
+	// Plain 关闭行编辑，按行读取标准输入
+	Plain       bool
+	HistoryFile string
+	// TUI 全屏界面，需要终端
+	TUI bool
+}
+
+// DefaultConfigFile 用户配置目录下的gochat/client.json
+func DefaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gochat", "client.json")
+}
+
+// DefaultHistoryFile 用户配置目录下的gochat/history
+func DefaultHistoryFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gochat", "history")
+}
+
+func newFlagSet(name string, options *Options, p *Profile, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	configFile := os.Getenv(EnvConfigFile)
+	if len(configFile) == 0 {
+		configFile = DefaultConfigFile()
+	}
+	fs.StringVar(&options.ConfigFile, "config", configFile, "json config file with profiles (env "+EnvConfigFile+")")
+	fs.StringVar(&options.ProfileName, "profile", "", "profile to use, defaults to default_profile of the config file")
+	fs.StringVar(&options.LogLevel, "log-level", "info", "log level: debug, info, error")
+	fs.StringVar(&options.LogFormat, "log-format", "text", "log format: text, json")
+	fs.StringVar(&options.Script, "script", "", "run commands from this file and exit, - for stdin")
+	fs.BoolVar(&options.JSONOutput, "json", false, "print batch events as json lines to stdout")
+	fs.DurationVar(&options.WaitTimeout, "wait-timeout", time.Second*10, "default timeout of the batch wait command")
+	fs.BoolVar(&options.Plain, "plain", false, "disable line editing and read stdin line by line")
+	fs.StringVar(&options.HistoryFile, "history", DefaultHistoryFile(), "command history file, empty to disable")
+	fs.BoolVar(&options.TUI, "tui", false, "full-screen terminal ui with user list and status bar")
+	fs.StringVar(&p.Address, "address", p.Address, "server address, prompt on stdin when empty")
+	fs.StringVar(&p.NickName, "nickname", p.NickName, "nickname used by auto login")
+	fs.StringVar(&p.Token, "token", p.Token, "handshake token")
+	fs.StringVar(&p.PasswordEnv, "password-env", p.PasswordEnv, "environment variable holding the password used by auto login")
+	fs.StringVar(&p.DownloadDir, "download-dir", p.DownloadDir, "directory for received files")
+	fs.BoolVar(&p.AutoLogin, "auto-login", p.AutoLogin, "login with nickname after connecting")
+	fs.BoolVar(&p.TLS.Enabled, "tls", p.TLS.Enabled, "connect with tls")
+	fs.StringVar(&p.TLS.CAFile, "tls-ca", p.TLS.CAFile, "ca file to verify the server certificate")
+	fs.StringVar(&p.TLS.CertFile, "tls-cert", p.TLS.CertFile, "client certificate file")
+	fs.StringVar(&p.TLS.KeyFile, "tls-key", p.TLS.KeyFile, "client private key file")
+	fs.StringVar(&p.TLS.ServerName, "tls-server-name", p.TLS.ServerName, "server name to verify, defaults to the address host")
+	fs.BoolVar(&p.TLS.InsecureSkipVerify, "tls-insecure", p.TLS.InsecureSkipVerify, "skip server certificate verification")
+	return fs
+}
+
+// Load 先读取配置文件中选中的profile，再用命令行覆盖
+func Load(name string, args []string, output io.Writer) (*Options, error) {
+	// 第一遍只为了拿到配置文件和profile名
+	options := &Options{}
+	if err := newFlagSet(name, options, &Profile{}, output).Parse(args); err != nil {
+		return nil, err
+	}
+	profile := &Profile{}
+	file, err := ReadFile(options.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.ProfileName) == 0 {
+		options.ProfileName = file.DefaultProfile
+	}
+	if len(options.ProfileName) != 0 {
+		p, ok := file.Profiles[options.ProfileName]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in %s, available: %s",
+				options.ProfileName, options.ConfigFile, strings.Join(file.Names(), ", "))
+		}
+		*profile = *p
+	}
+	if err := newFlagSet(name, options, profile, ioutil.Discard).Parse(args); err != nil {
+		return nil, err
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	if options.TUI && (options.Plain || len(options.Script) != 0) {
+		return nil, errors.New("-tui can not be used with -plain or -script")
+	}
+	options.Profile = profile
+	return options, nil
+}
+
+// ReadFile 文件不存在时返回空配置
+func ReadFile(path string) (*File, error) {
+	file := &File{Profiles: make(map[string]*Profile)}
+	if len(path) == 0 {
+		return file, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config file error: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(file); err != nil {
+		return nil, fmt.Errorf("parse config file %s error: %w", path, err)
+	}
+	if len(file.DefaultProfile) != 0 {
+		if _, ok := file.Profiles[file.DefaultProfile]; !ok {
+			return nil, fmt.Errorf("default_profile %q not found in %s", file.DefaultProfile, path)
+		}
+	}
+	return file, nil
+}
+
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Profile) Validate() error {
+	if len(p.Address) != 0 {
+		if _, _, err := net.SplitHostPort(p.Address); err != nil {
+			return fmt.Errorf("invalid address %q", p.Address)
+		}
+	}
+	if p.AutoLogin && len(strings.TrimSpace(p.NickName)) == 0 {
+		return errors.New("auto login requires a nickname")
+	}
+	if p.AutoLogin && len(p.PasswordEnv) != 0 && len(p.Password()) == 0 {
+		return fmt.Errorf("auto login password environment variable %s is empty", p.PasswordEnv)
+	}
+	if (len(p.TLS.CertFile) != 0) != (len(p.TLS.KeyFile) != 0) {
+		return errors.New("tls cert file and key file must be set together")
+	}
+	return nil
+}
@@ -3,49 +3,128 @@ package main
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"gochat/cmd/chatclient/terminal"
+	"gochat/common"
 	"gochat/common/util"
 	"gochat/goclient"
 	"io"
-	"log"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type commandDispatcher struct {
-	*bufio.Scanner
+	reader     LineReader
+	lock       sync.RWMutex
 	commandMap map[string]*goclient.Command
-	client     *goclient.Client
+	// remote 服务端公布的命令，每次收到新的命令目录时整体替换
+	remote map[string]*goclient.Command
+	client *goclient.Client
+	logger common.Logger
+	// closeOnEOF 输入结束时关闭客户端，交互终端中按Ctrl-D即退出
+	closeOnEOF bool
+}
+
+// LineReader 命令的输入来源
+type LineReader interface {
+	ReadLine() (string, error)
+}
+
+type scannerReader struct {
+	*bufio.Scanner
+}
+
+// NewScannerReader 按行读取，用于stdin不是终端或者关闭了行编辑的情况
+func NewScannerReader(reader io.Reader) LineReader {
+	return &scannerReader{bufio.NewScanner(reader)}
+}
+
+func (r *scannerReader) ReadLine() (string, error) {
+	if r.Scan() {
+		return r.Text(), nil
+	}
+	if err := r.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+var errCommandNotFound = errors.New("command not found")
+
+// parseError ParseFunc返回的错误
+type parseError struct {
+	error
+}
+
+func (e *parseError) Unwrap() error {
+	return e.error
 }
 
 func (c *commandDispatcher) Dispatch() {
-	for c.Scan() {
-		str := c.Text()
-		if len(str) == 0 {
+	for {
+		str, err := c.reader.ReadLine()
+		if errors.Is(err, terminal.ErrInterrupt) {
 			continue
 		}
-		arr := strings.SplitN(str, " ", 2)
-		command, ok := c.commandMap[arr[0]]
-		if !ok {
-			log.Println("command not found, you can use [list] command to get command list")
-			continue
-		}
-		params := ""
-		if len(arr) > 1 {
-			params = arr[1]
-		}
-		if !command.UseParseFunc {
-			if err := command.LocalParseFunc(params); err != nil {
-				log.Println(err)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.logger.Error("read command error", common.Err(err))
 			}
-			continue
+			break
 		}
-		message, err := command.ParseFunc(params)
-		if err != nil {
-			log.Println("parse params error")
+		if len(strings.TrimSpace(str)) == 0 {
 			continue
 		}
-		c.client.SendMessage(message)
+		command, _, err := c.execute(str)
+		var parseErr *parseError
+		var usageErr *goclient.UsageError
+		switch {
+		case err == nil:
+		case errors.Is(err, errCommandNotFound):
+			c.logger.Info("command not found, you can use [list] command to get command list")
+		case errors.As(err, &usageErr):
+			c.logger.Info("invalid arguments", common.F("command", usageErr.Command.Command),
+				common.Err(usageErr.Err), common.F("usage", usageErr.Command.Usage()))
+		case command == nil:
+			c.logger.Info("invalid command line", common.Err(err))
+		case errors.As(err, &parseErr):
+			c.logger.Info("parse params error", common.F("command", command.Command), common.Err(parseErr.error))
+		default:
+			c.logger.Error("run command error", common.F("command", command.Command), common.Err(err))
+		}
+	}
+	c.logger.Debug("quit dispatcher")
+	if c.closeOnEOF {
+		_ = c.client.Close()
 	}
-	log.Println("quit dispatcher")
+}
+
+// execute 执行一行命令，发送了消息时返回其RequestID
+func (c *commandDispatcher) execute(line string) (*goclient.Command, int64, error) {
+	tokens, err := goclient.Tokenize(line)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(tokens) == 0 {
+		return nil, 0, nil
+	}
+	command, ok := c.lookup(tokens[0])
+	if !ok {
+		return nil, 0, errCommandNotFound
+	}
+	args, err := command.Parse(tokens[1:])
+	if err != nil {
+		return command, 0, err
+	}
+	if !command.UseParseFunc {
+		return command, 0, command.LocalParseFunc(args)
+	}
+	message, err := command.ParseFunc(args)
+	if err != nil {
+		return command, 0, &parseError{err}
+	}
+	return command, c.client.SendMessage(message), nil
 }
 
 func (c *commandDispatcher) Register(command *goclient.Command) error {
@@ -56,6 +135,9 @@ func (c *commandDispatcher) Register(command *goclient.Command) error {
 	if len(command.Command) == 0 {
 		return errors.New("invalid command")
 	}
+	if err := command.Validate(); err != nil {
+		return fmt.Errorf("invalid command %s: %w", command.Command, err)
+	}
 	if command.UseParseFunc {
 		if command.ParseFunc == nil {
 			return errors.New("ParseFunc not found")
@@ -71,6 +153,8 @@ func (c *commandDispatcher) Register(command *goclient.Command) error {
 			return errors.New("LocalParseFunc not found")
 		}
 	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	_, ok := c.commandMap[command.Command]
 	if ok {
 		return errors.New("duplicate command")
@@ -86,57 +170,157 @@ func (c *commandDispatcher) Register(command *goclient.Command) error {
 	return nil
 }
 
-func NewCommandDispatcher(client *goclient.Client, reader io.Reader) goclient.Dispatcher {
+func (c *commandDispatcher) lookup(name string) (*goclient.Command, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	command, ok := c.commandMap[name]
+	return command, ok
+}
+
+// names 所有命令名和别名
+func (c *commandDispatcher) names() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	names := make([]string, 0, len(c.commandMap))
+	for name := range c.commandMap {
+		names = append(names, name)
+	}
+	return names
+}
+
+// secret 输入的命令有Secret参数时返回true，这样的输入不保存到历史
+func (c *commandDispatcher) secret(line string) bool {
+	tokens, err := goclient.Tokenize(line)
+	if err != nil || len(tokens) == 0 {
+		return false
+	}
+	command, ok := c.lookup(tokens[0])
+	return ok && command.HasSecret()
+}
+
+// commands 按名称排序，别名不重复出现
+func (c *commandDispatcher) commands() []*goclient.Command {
+	c.lock.RLock()
+	commands := make([]*goclient.Command, 0, len(c.commandMap))
+	for name, command := range c.commandMap {
+		if name == command.Command {
+			commands = append(commands, command)
+		}
+	}
+	c.lock.RUnlock()
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Command < commands[j].Command
+	})
+	return commands
+}
+
+// isRemote 命令是否来自服务端的命令目录
+func (c *commandDispatcher) isRemote(command *goclient.Command) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.remote[command.Command] == command
+}
+
+// setRemoteCommands 用服务端公布的命令替换上一次公布的命令，与本地命令同名的命令和别名被忽略，
+// 返回实际注册的命令名
+func (c *commandDispatcher) setRemoteCommands(commands []*goclient.Command) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, command := range c.commandMap {
+		if c.remote[command.Command] == command {
+			delete(c.commandMap, name)
+		}
+	}
+	c.remote = make(map[string]*goclient.Command)
+	var added []string
+	for _, command := range commands {
+		if _, ok := c.commandMap[command.Command]; ok {
+			continue
+		}
+		aliases := command.Alias[:0]
+		for _, alias := range command.Alias {
+			if _, ok := c.commandMap[alias]; !ok {
+				aliases = append(aliases, alias)
+			}
+		}
+		command.Alias = aliases
+		c.remote[command.Command] = command
+		c.commandMap[command.Command] = command
+		for _, alias := range command.Alias {
+			c.commandMap[alias] = command
+		}
+		added = append(added, command.Command)
+	}
+	return added
+}
+
+// onCatalog 处理服务端登录后发来的命令目录
+func (c *commandDispatcher) onCatalog(_ common.Context, specs []common.CommandSpec) error {
+	commands := make([]*goclient.Command, 0, len(specs))
+	for _, spec := range specs {
+		command, err := goclient.CommandFromSpec(spec)
+		if err != nil {
+			c.logger.Error("invalid server command", common.F("command", spec.Name), common.Err(err))
+			continue
+		}
+		commands = append(commands, command)
+	}
+	added := c.setRemoteCommands(commands)
+	c.logger.Debug("server command catalog received", common.F("commands", len(specs)), common.F("added", strings.Join(added, ",")))
+	return nil
+}
+
+func NewCommandDispatcher(client *goclient.Client, reader LineReader) *commandDispatcher {
 	dispatcher := &commandDispatcher{
-		Scanner:    bufio.NewScanner(reader),
+		reader:     reader,
 		commandMap: make(map[string]*goclient.Command),
+		remote:     make(map[string]*goclient.Command),
 		client:     client,
+		logger:     client.Logger(),
 	}
 	listCommand := &goclient.Command{
 		Command: "list",
-		LocalParseFunc: func(params string) error {
-			displayTips := false
-			if strings.TrimSpace(params) == "-all" {
-				displayTips = true
-			}
+		Flags:   []goclient.Flag{{Name: "all", Type: goclient.ArgBool, Help: "display command tips"}},
+		LocalParseFunc: func(args *goclient.Args) error {
 			sb := &strings.Builder{}
 			sb.WriteString("now command list:\n")
-			for _, command := range dispatcher.commandMap {
+			for _, command := range dispatcher.commands() {
 				sb.WriteString("command:[")
-				sb.WriteString(command.Command)
-				sb.WriteString("]\n")
-				if displayTips {
+				sb.WriteString(command.Usage())
+				sb.WriteString("]")
+				if dispatcher.isRemote(command) {
+					sb.WriteString(" (server)")
+				}
+				sb.WriteString("\n")
+				if args.Bool("all") && len(command.Tips) != 0 {
+					sb.WriteString("\t")
 					sb.WriteString(command.Tips)
 					sb.WriteString("\n")
 				}
 			}
-			log.Println(sb.String())
+			dispatcher.logger.Info(sb.String())
 			return nil
 		},
 		Tips: "display all command info, use option -all can display command tips",
 	}
 	helpCommand := &goclient.Command{
 		Command: "help",
-		LocalParseFunc: func(params string) error {
-			str := strings.TrimSpace(params)
-			if str == "" {
-				log.Println("please add command after help")
-				return nil
-			}
-			command, ok := dispatcher.commandMap[str]
+		Args:    []goclient.Arg{{Name: "command", Help: "command name or alias", Complete: goclient.CompleteCommand}},
+		LocalParseFunc: func(args *goclient.Args) error {
+			command, ok := dispatcher.lookup(args.String("command"))
 			if !ok {
-				log.Println("not found command")
+				dispatcher.logger.Info("not found command")
 				return nil
 			}
-			log.Println(command.Tips)
+			dispatcher.logger.Info(command.Help())
 			return nil
 		},
-		Tips: "show command tips, use likes help [command], example: help help",
+		Tips: "show command usage and tips, example: help help",
 	}
 	exitCommand := &goclient.Command{
 		Command: "exit",
-		LocalParseFunc: func(params string) error {
-			log.Println("exit client success")
+		LocalParseFunc: func(_ *goclient.Args) error {
+			dispatcher.logger.Info("exit client success")
 			_ = client.Close()
 			return nil
 		},
@@ -2,16 +2,16 @@ package main
 
 import (
 	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"gochat/common"
 	"gochat/common/message/enum"
 	"gochat/common/message/msg"
-	"gochat/common/util"
 	"gochat/goclient"
 	"io"
-	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -23,15 +23,21 @@ type fileTransferHandler struct {
 	sendFile            *os.File
 	lastSendFileTime    int64
 	sendBlock           int64
+	sentBytes           int64
 	sendBuff            []byte
 	receiveFileEntity   *msg.FileTransformEntity
 	receiveLock         *sync.Mutex
 	receiveFile         *os.File
 	receiveBlock        int64
+	receivedBytes       int64
 	lastReceiveFileTime int64
 	msgHandler          map[int8]func(ctx common.Context, file *msg.FileTransformEntity) error
 	timeout             int64
 	client              *goclient.Client
+	logger              common.Logger
+	ticker              *time.Ticker
+	done                chan struct{}
+	downloadDir         string
 }
 
 func NewFileTransferHandler(client *goclient.Client, timeout time.Duration) *fileTransferHandler {
@@ -41,6 +47,7 @@ func NewFileTransferHandler(client *goclient.Client, timeout time.Duration) *fil
 		receiveLock: &sync.Mutex{},
 		timeout:     int64(timeout.Seconds()),
 		client:      client,
+		logger:      client.Logger(),
 	}
 	fileTransfer.msgHandler = map[int8]func(ctx common.Context, file *msg.FileTransformEntity) error{
 		msg.FileWaitingSend:   fileTransfer.FileStateWaitingSend,
@@ -52,62 +59,60 @@ func NewFileTransferHandler(client *goclient.Client, timeout time.Duration) *fil
 	return fileTransfer
 }
 
-func (h *fileTransferHandler) commands() []*goclient.Command {
-	return []*goclient.Command{h.confirmAccept(), h.rejectAccept(), h.trySendFile()}
-}
-
 func (h *fileTransferHandler) trySendFile() *goclient.Command {
 	return &goclient.Command{
 		Command:      "sendfile",
 		UseParseFunc: false,
-		LocalParseFunc: func(params string) error {
-			arr := strings.SplitN(params, " ", 3)
-			if len(arr) < 3 {
-				log.Println("invalid params")
-				return nil
-			}
-			localID, remoteID, path := strings.TrimSpace(arr[0]), strings.TrimSpace(arr[1]), strings.TrimSpace(arr[2])
-			if len(localID) == 0 || len(remoteID) == 0 || len(path) == 0 {
-				log.Println("invalid params")
-				return nil
-			}
-			if err := h.notifySendFile(localID, remoteID, path); err != nil {
-				log.Println(err)
+		Args: []goclient.Arg{
+			{Name: "localID", Help: "your ID", Complete: goclient.CompleteUser},
+			{Name: "remoteID", Help: "receiver ID", Complete: goclient.CompleteUser},
+			{Name: "path", Help: "quote paths with spaces", Complete: goclient.CompleteFile},
+		},
+		LocalParseFunc: func(args *goclient.Args) error {
+			if err := h.notifySendFile(args.String("localID"), args.String("remoteID"), args.String("path")); err != nil {
+				h.logger.Error("send file error", common.Err(err))
 			}
 			return nil
 		},
-		Tips: "use like: sendfile [localID] [remoteID] [path]",
+		Tips: "send a file to another user",
 	}
 }
 
 func (h *fileTransferHandler) confirmAccept() *goclient.Command {
 	return &goclient.Command{
 		Command: "confirm",
-		ParseFunc: func(params string) (*common.Message, error) {
+		Args:    []goclient.Arg{{Name: "path", Optional: true, Help: "defaults to the sender's file name", Complete: goclient.CompleteFile}},
+		ParseFunc: func(args *goclient.Args) (*common.Message, error) {
+			params := args.String("path")
 			h.receiveLock.Lock()
 			defer h.receiveLock.Unlock()
 			if h.receiveFileEntity == nil {
-				log.Println("not found receiveFileEntity")
+				h.logger.Info("not found receiveFileEntity")
 				return nil, nil
 			}
 			if h.receiveFileEntity.State == msg.FileWaitingSend {
-				_, err := os.Stat(params)
-				if err != nil && !os.IsNotExist(err) {
-					log.Println(err)
+				if strings.HasSuffix(params, string(os.PathSeparator)) {
+					h.logger.Info("不能使用目录当文件名")
 					return nil, nil
 				}
-				if os.IsExist(err) {
-					log.Println("该文件已存在，请换个文件名")
+				path, err := h.receivePath(strings.TrimSpace(params), h.receiveFileEntity.FileName)
+				if err != nil {
+					h.logger.Info(err.Error())
 					return nil, nil
 				}
-				if strings.HasSuffix(params, string(os.PathSeparator)) {
-					log.Println("不能使用目录当文件名")
+				_, err = os.Stat(path)
+				if err != nil && !os.IsNotExist(err) {
+					h.logger.Error("stat file error", common.Err(err))
+					return nil, nil
+				}
+				if err == nil {
+					h.logger.Info("该文件已存在，请换个文件名")
 					return nil, nil
 				}
-				log.Println("开始接受文件")
-				file, err := os.Create(params)
+				h.logger.Info("开始接受文件", common.F("path", path))
+				file, err := os.Create(path)
 				if err != nil {
-					log.Printf("error=%s, please retry", err)
+					h.logger.Error("create file error, please retry", common.Err(err))
 					return nil, nil
 				}
 				h.receiveFile = file
@@ -123,18 +128,40 @@ func (h *fileTransferHandler) confirmAccept() *goclient.Command {
 			}, nil
 		},
 		UseParseFunc: true,
-		Tips:         "use confirm to receive file, use like: confirm [path]",
+		Tips:         "accept the pending file",
 	}
 }
 
+// SetDownloadDir 设置后confirm的相对路径和缺省文件名都保存到该目录
+func (h *fileTransferHandler) SetDownloadDir(dir string) {
+	h.downloadDir = dir
+}
+
+func (h *fileTransferHandler) receivePath(path, fileName string) (string, error) {
+	if len(path) == 0 {
+		path = filepath.Base(filepath.Clean("/" + fileName))
+		if path == string(os.PathSeparator) || path == "." {
+			return "", errors.New("请指定保存的文件名")
+		}
+	}
+	if len(h.downloadDir) == 0 || filepath.IsAbs(path) {
+		return path, nil
+	}
+	path = filepath.Join(h.downloadDir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
 func (h *fileTransferHandler) rejectAccept() *goclient.Command {
 	return &goclient.Command{
 		Command: "reject",
-		ParseFunc: func(_ string) (*common.Message, error) {
+		ParseFunc: func(_ *goclient.Args) (*common.Message, error) {
 			h.receiveLock.Lock()
 			defer h.receiveLock.Unlock()
 			if h.receiveFileEntity == nil {
-				log.Println("not found receiveFileEntity")
+				h.logger.Info("not found receiveFileEntity")
 				return nil, nil
 			}
 			if h.receiveFileEntity.State != msg.FileWaitingSend {
@@ -179,10 +206,12 @@ func (h *fileTransferHandler) FileStateAck(ctx common.Context, fileTransformEnti
 	})
 	h.lastSendFileTime = time.Now().Unix()
 	h.sendBlock++
-	log.Printf("send file blocksize=[%d], [%d/%d]\n", len(h.sendFileEntity.Content), h.sendBlock,
-		int64(math.Round(float64(h.sendFileEntity.FileSize)/float64(len(h.sendBuff)))))
+	h.sentBytes += int64(n)
+	h.logger.Info("send file block", common.F("blocksize", len(h.sendFileEntity.Content)),
+		common.F("progress", fmt.Sprintf("%d/%d", h.sendBlock,
+			int64(math.Round(float64(h.sendFileEntity.FileSize)/float64(len(h.sendBuff)))))))
 	if eofFlag {
-		log.Println("send file complete")
+		h.logger.Info("send file complete")
 		h.resetSendFile(true)
 	}
 	return err
@@ -195,7 +224,7 @@ func (h *fileTransferHandler) FileStateReject(_ common.Context, fileTransformEnt
 		return nil
 	}
 	h.resetSendFile(true)
-	log.Println("对方拒绝了你的请求")
+	h.logger.Info("对方拒绝了你的请求")
 	return nil
 }
 
@@ -204,8 +233,8 @@ func (h *fileTransferHandler) FileStateWaitingSend(ctx common.Context, fileTrans
 	defer h.receiveLock.Unlock()
 	entity := h.receiveFileEntity
 	if entity != nil {
-		log.Printf("ID:%s 想要给你发送文件，文件名:%s, 文件大小:%db，但正在接受文件中所以自动拒绝", fileTransformEntity.From,
-			fileTransformEntity.FileName, fileTransformEntity.FileSize)
+		h.logger.Info(fmt.Sprintf("ID:%s 想要给你发送文件，文件名:%s, 文件大小:%db，但正在接受文件中所以自动拒绝", fileTransformEntity.From,
+			fileTransformEntity.FileName, fileTransformEntity.FileSize))
 		return ctx.Write(&common.Message{
 			Code: enum.FileTransfer,
 			RawData: &msg.FileTransformEntity{
@@ -216,9 +245,9 @@ func (h *fileTransferHandler) FileStateWaitingSend(ctx common.Context, fileTrans
 		})
 	}
 	h.receiveFileEntity = fileTransformEntity
-	log.Printf("ID%s 想要给你发送文件，文件名:%s, 文件大小:%db", fileTransformEntity.From,
-		fileTransformEntity.FileName, fileTransformEntity.FileSize)
-	log.Printf("请回复confirm [filepath]去接收或reject拒绝接收")
+	h.logger.Info(fmt.Sprintf("ID%s 想要给你发送文件，文件名:%s, 文件大小:%db", fileTransformEntity.From,
+		fileTransformEntity.FileName, fileTransformEntity.FileSize))
+	h.logger.Info("请回复confirm [filepath]去接收或reject拒绝接收")
 	return nil
 }
 
@@ -234,11 +263,13 @@ func (h *fileTransferHandler) FileStateSending(ctx common.Context, fileTransform
 	if err != nil {
 		return err
 	}
-	log.Printf("receiving file blocksize=[%d], [%d/%d]\n", len(fileTransformEntity.Content), h.receiveBlock,
-		int64(math.Ceil(float64(h.receiveFileEntity.FileSize)/float64(len(h.sendBuff)))))
+	h.logger.Info("receiving file block", common.F("blocksize", len(fileTransformEntity.Content)),
+		common.F("progress", fmt.Sprintf("%d/%d", h.receiveBlock,
+			int64(math.Ceil(float64(h.receiveFileEntity.FileSize)/float64(len(h.sendBuff)))))))
 	if _, err := h.receiveFile.Write(bytes); err != nil {
 		return err
 	}
+	h.receivedBytes += int64(len(bytes))
 	return ctx.Write(&common.Message{
 		Code: enum.FileTransfer,
 		RawData: &msg.FileTransformEntity{
@@ -261,24 +292,26 @@ func (h *fileTransferHandler) FileStateCompleted(_ common.Context, fileTransform
 	if err != nil {
 		return err
 	}
-	log.Printf("receiving file blocksize=[%d], [%d/%d]\n", len(fileTransformEntity.Content), h.receiveBlock,
-		int64(math.Ceil(float64(h.receiveFileEntity.FileSize)/float64(len(h.sendBuff)))))
+	h.logger.Info("receiving file block", common.F("blocksize", len(fileTransformEntity.Content)),
+		common.F("progress", fmt.Sprintf("%d/%d", h.receiveBlock,
+			int64(math.Ceil(float64(h.receiveFileEntity.FileSize)/float64(len(h.sendBuff)))))))
 	if _, err := h.receiveFile.Write(bytes); err != nil {
 		return err
 	}
-	log.Printf("receive file completed, local filename=%s", h.receiveFile.Name())
+	h.receivedBytes += int64(len(bytes))
+	h.logger.Info("receive file completed", common.F("filename", h.receiveFile.Name()))
 	h.resetReceiveFile(true)
 	return nil
 }
 
 func (h *fileTransferHandler) OnMessage(ctx common.Context, rawMessage *common.RawMessage) error {
 	message := &msg.FileTransformEntity{}
-	if err := json.Unmarshal(rawMessage.RawData, message); err != nil {
+	if err := ctx.Unmarshal(rawMessage.RawData, message); err != nil {
 		return err
 	}
 	f, ok := h.msgHandler[message.State]
 	if !ok {
-		log.Println("invalid state")
+		h.logger.Info("invalid state", common.F("state", message.State))
 		return nil
 	}
 	return f(ctx, message)
@@ -288,38 +321,78 @@ func (h *fileTransferHandler) OnActive(_ common.Context) {}
 
 func (h *fileTransferHandler) OnClose(_ common.Context) {}
 
-func (h *fileTransferHandler) OnInit(_ common.Env) {
-	for _, command := range h.commands() {
-		util.AssertNotError(h.client.Register(command))
-	}
-	ticker := time.NewTicker(time.Second * 5)
-	go func() {
-		log.Println("[start file watch]")
-		for {
-			<-ticker.C
+func (h *fileTransferHandler) OnInit(_ common.Env) {}
+
+func (h *fileTransferHandler) OnRemove(_ common.Env) {}
+
+func (h *fileTransferHandler) Name() string {
+	return "filetransfer"
+}
+
+func (h *fileTransferHandler) Dependencies() []string {
+	return nil
+}
+
+func (h *fileTransferHandler) Handlers() map[common.MessageCode]common.Handler {
+	return map[common.MessageCode]common.Handler{enum.FileTransfer: h}
+}
+
+// CommandSpecs 客户端模块不向其他端公布命令，本地命令由Commands提供
+func (h *fileTransferHandler) CommandSpecs() []common.CommandSpec {
+	return nil
+}
+
+func (h *fileTransferHandler) Commands() []*goclient.Command {
+	return []*goclient.Command{h.confirmAccept(), h.rejectAccept(), h.trySendFile()}
+}
+
+func (h *fileTransferHandler) Init(_ common.Env) error {
+	return nil
+}
+
+func (h *fileTransferHandler) Start() error {
+	h.ticker = time.NewTicker(time.Second * 5)
+	h.done = make(chan struct{})
+	go h.watch(h.ticker, h.done)
+	return nil
+}
+
+func (h *fileTransferHandler) Stop() error {
+	h.ticker.Stop()
+	close(h.done)
+	return nil
+}
+
+// watch 定时检查收发文件是否超时，超时后释放文件
+func (h *fileTransferHandler) watch(ticker *time.Ticker, done chan struct{}) {
+	h.logger.Debug("[start file watch]")
+	for {
+		select {
+		case <-done:
+			h.logger.Debug("[stop file watch]")
+			return
+		case <-ticker.C:
+		}
+		if !h.checkSendFileTimeout() {
+			h.logger.Info("send file timeout")
+			h.sendLock.Lock()
 			if !h.checkSendFileTimeout() {
-				log.Println("checkSendFileTimeout false")
-				h.sendLock.Lock()
-				if !h.checkSendFileTimeout() {
-					h.resetSendFile(false)
-				}
-				h.sendLock.Unlock()
+				h.resetSendFile(false)
 			}
+			h.sendLock.Unlock()
+		}
 
+		if !h.checkReceiveFileTimeout() {
+			h.logger.Info("receive file timeout")
+			h.receiveLock.Lock()
 			if !h.checkReceiveFileTimeout() {
-				log.Println("checkReceiveFileTimeout false")
-				h.receiveLock.Lock()
-				if !h.checkReceiveFileTimeout() {
-					h.resetReceiveFile(false)
-				}
-				h.receiveLock.Unlock()
+				h.resetReceiveFile(false)
 			}
+			h.receiveLock.Unlock()
 		}
-	}()
+	}
 }
 
-func (h *fileTransferHandler) OnRemove(_ common.Env) {}
-
 func (h *fileTransferHandler) checkReceiveFileTimeout() bool {
 	if h.receiveFileEntity != nil && h.receiveFile != nil && h.lastReceiveFileTime != 0 {
 		return h.lastReceiveFileTime+h.timeout > time.Now().Unix()
@@ -338,8 +411,8 @@ func (h *fileTransferHandler) notifySendFile(localID, remoteID, filepath string)
 	h.sendLock.Lock()
 	defer h.sendLock.Unlock()
 	if h.sendFileEntity != nil {
-		log.Printf("当前存在发送中文件，请等待发送完成, 若正在等待对方确认中，请等待%d秒后自动取消发送\n",
-			h.lastSendFileTime+h.timeout-time.Now().Unix())
+		h.logger.Info(fmt.Sprintf("当前存在发送中文件，请等待发送完成, 若正在等待对方确认中，请等待%d秒后自动取消发送",
+			h.lastSendFileTime+h.timeout-time.Now().Unix()))
 		return nil
 	}
 	fileInfo, err := os.Stat(filepath)
@@ -347,7 +420,7 @@ func (h *fileTransferHandler) notifySendFile(localID, remoteID, filepath string)
 		return err
 	}
 	if fileInfo.IsDir() {
-		log.Println("不支持文件夹传输")
+		h.logger.Info("不支持文件夹传输")
 		return nil
 	}
 	file, err := os.Open(filepath)
@@ -368,7 +441,7 @@ func (h *fileTransferHandler) notifySendFile(localID, remoteID, filepath string)
 		Code:    enum.FileTransfer,
 		RawData: h.sendFileEntity,
 	})
-	log.Printf("请求发送成功，正在等待对方接受或拒绝响应， 最多等待%d秒后自动取消发送\n", h.timeout)
+	h.logger.Info(fmt.Sprintf("请求发送成功，正在等待对方接受或拒绝响应， 最多等待%d秒后自动取消发送", h.timeout))
 	return nil
 }
 
@@ -376,12 +449,12 @@ func (h *fileTransferHandler) resetReceiveFile(noneError bool) {
 	if h.receiveFile != nil {
 		name := h.receiveFile.Name()
 		if err := h.receiveFile.Close(); err != nil {
-			log.Println(err)
+			h.logger.Error("close receive file error", common.Err(err))
 		}
 		if !noneError {
-			log.Printf("remove receive failed file:" + name)
+			h.logger.Info("remove receive failed file", common.F("filename", name))
 			if err := os.Remove(name); err != nil {
-				log.Println(err)
+				h.logger.Error("remove receive file error", common.Err(err))
 			}
 		}
 	}
@@ -389,34 +462,75 @@ func (h *fileTransferHandler) resetReceiveFile(noneError bool) {
 	h.receiveFileEntity = nil
 	h.lastReceiveFileTime = 0
 	h.receiveBlock = 0
+	h.receivedBytes = 0
 }
 
 func (h *fileTransferHandler) resetSendFile(noneError bool) {
 	if h.sendFile != nil {
 		if err := h.sendFile.Close(); err != nil {
-			log.Println(err)
+			h.logger.Error("close send file error", common.Err(err))
 		}
 		if !noneError {
-			log.Printf("send file failed, release fd")
+			h.logger.Info("send file failed, release fd")
 		}
 	}
 	h.sendFile = nil
 	h.sendFileEntity = nil
 	h.lastSendFileTime = 0
 	h.sendBlock = 0
+	h.sentBytes = 0
+}
+
+// fileTransferStatus 进行中的文件传输，用于状态栏显示
+type fileTransferStatus struct {
+	Sending  bool
+	FileName string
+	Peer     string
+	Done     int64
+	Size     int64
+	// Waiting 等待对方或自己确认
+	Waiting bool
+}
+
+func (h *fileTransferHandler) Transfers() []fileTransferStatus {
+	var transfers []fileTransferStatus
+	h.sendLock.Lock()
+	if h.sendFileEntity != nil {
+		transfers = append(transfers, fileTransferStatus{
+			Sending:  true,
+			FileName: h.sendFileEntity.FileName,
+			Peer:     h.sendFileEntity.To,
+			Done:     h.sentBytes,
+			Size:     h.sendFileEntity.FileSize,
+			Waiting:  h.sendBlock == 0,
+		})
+	}
+	h.sendLock.Unlock()
+	h.receiveLock.Lock()
+	if h.receiveFileEntity != nil {
+		transfers = append(transfers, fileTransferStatus{
+			FileName: h.receiveFileEntity.FileName,
+			Peer:     h.receiveFileEntity.From,
+			Done:     h.receivedBytes,
+			Size:     h.receiveFileEntity.FileSize,
+			Waiting:  h.receiveFileEntity.State == msg.FileWaitingSend,
+		})
+	}
+	h.receiveLock.Unlock()
+	return transfers
 }
 
 func (h *fileTransferHandler) checkSend(fileTransformEntity *msg.FileTransformEntity, targetState int8) bool {
 	if h.sendFileEntity == nil {
-		log.Println("invalid file ack")
+		h.logger.Info("invalid file ack")
 		return false
 	}
 	if h.sendFileEntity.From != fileTransformEntity.To || h.sendFileEntity.To != fileTransformEntity.From {
-		log.Println("invalid file ack sender")
+		h.logger.Info("invalid file ack sender")
 		return false
 	}
 	if targetState > 0 && h.sendFileEntity.State != targetState {
-		log.Println("invalid file state")
+		h.logger.Info("invalid file state")
 		return false
 	}
 	return true
@@ -424,20 +538,20 @@ func (h *fileTransferHandler) checkSend(fileTransformEntity *msg.FileTransformEn
 
 func (h *fileTransferHandler) validateReceiveFile(fileTransformEntity *msg.FileTransformEntity, targetState int8) bool {
 	if h.receiveFileEntity == nil {
-		log.Println("invalid sending request")
+		h.logger.Info("invalid sending request")
 		return false
 	}
 	if h.receiveFileEntity.From != fileTransformEntity.From || h.receiveFileEntity.To != fileTransformEntity.To {
-		log.Println("invalid sending sender")
+		h.logger.Info("invalid sending sender")
 		return false
 	}
 	if h.receiveFileEntity.FileSize != fileTransformEntity.FileSize ||
 		h.receiveFileEntity.FileName != fileTransformEntity.FileName {
-		log.Println("invalid file")
+		h.logger.Info("invalid file")
 		return false
 	}
 	if targetState > 0 && h.receiveFileEntity.State != targetState {
-		log.Println("invalid file state")
+		h.logger.Info("invalid file state")
 		return false
 	}
 	return true
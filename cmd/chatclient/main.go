@@ -1,36 +1,196 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"gochat/cmd/chatclient/config"
+	"gochat/cmd/chatclient/terminal"
 	"gochat/common"
 	"gochat/common/message/enum"
+	"gochat/common/message/msg"
 	"gochat/common/util"
 	"gochat/goclient"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
 func main() {
-	log.Println("输入要连接的服务器IP端口，不输入默认为localhost:8080")
-	address := util.ScanAddress("localhost:8080")
-	cli, err := goclient.NewClient(address)
+	options, err := config.Load(os.Args[0], os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	profile := options.Profile
+	// 交互终端中使用行编辑器，日志经由编辑器输出，不会打断正在输入的命令；
+	// 全屏模式下日志进入消息区。聊天内容写到out，不受日志级别和格式影响
+	var editor *terminal.LineEditor
+	var screen *terminal.Screen
+	var logOutput io.Writer = os.Stderr
+	var out io.Writer = os.Stdout
+	if options.JSONOutput {
+		// stdout留给JSON事件
+		out = os.Stderr
+	}
+	interactive := len(options.Script) == 0 && !options.Plain && terminal.IsTerminal(int(os.Stdin.Fd()))
+	if options.TUI && !(interactive && terminal.IsTerminal(int(os.Stdout.Fd()))) {
+		log.Fatal("-tui requires an interactive terminal")
+	}
+	var historyErr error
+	if interactive {
+		history, err := terminal.LoadHistory(options.HistoryFile, 1000)
+		if err != nil {
+			historyErr = err
+			history, _ = terminal.LoadHistory("", 1000)
+		}
+		if options.TUI {
+			screen = terminal.NewScreen(os.Stdout)
+			if err := screen.Start(); err != nil {
+				log.Fatal(err)
+			}
+			defer screen.Close()
+			editor = terminal.NewLineEditor(os.Stdin, screen.Output(), history)
+			screen.Attach(editor)
+			logOutput = screen
+			out = screen
+		} else {
+			editor = terminal.NewLineEditor(os.Stdin, os.Stderr, history)
+			logOutput = editor
+			out = editor
+		}
+		defer editor.Close()
+	}
+	logger, err := common.ParseLogger(logOutput, options.LogLevel, options.LogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if screen != nil {
+		logger.SetTimeFormat("15:04:05")
+	}
+	if historyErr != nil {
+		logger.Error("load history error", common.Err(historyErr))
+	}
+	if len(options.ProfileName) != 0 {
+		logger.Info("using profile", common.F("profile", options.ProfileName), common.F("file", options.ConfigFile))
+	}
+	address := profile.Address
+	if len(address) == 0 {
+		if options.Script == "-" {
+			log.Fatal("address is required when the script is read from stdin")
+		}
+		logger.Info("输入要连接的服务器IP端口，不输入默认为localhost:8080")
+		if editor != nil {
+			address, err = scanAddress(editor, "localhost:8080")
+			if err != nil {
+				return
+			}
+		} else {
+			address = util.ScanAddress("localhost:8080")
+		}
+	}
+	tlsConfig, err := profile.TLS.Load(address)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
+	}
+	cli, err := goclient.NewClientWithConfig(goclient.Config{
+		Address:   address,
+		Logger:    logger,
+		Token:     profile.Token,
+		TLSConfig: tlsConfig,
+	})
+	if err != nil {
+		if screen != nil {
+			// 回到普通屏幕，避免错误信息随备用屏幕一起消失
+			_ = editor.Close()
+			_ = screen.Close()
+		}
+		logger.Error("connect server error", common.Err(err))
+		if len(options.Script) != 0 {
+			os.Exit(2)
+		}
 		time.Sleep(time.Second * 5)
 		return
 	}
-	cli.SetDispatcher(NewCommandDispatcher(cli, os.Stdin))
+	var batch *batchRunner
+	var dispatcher *commandDispatcher
+	if len(options.Script) != 0 {
+		batch, err = NewBatchRunner(cli, options.Script, options.WaitTimeout, options.JSONOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cli.SetDispatcher(batch)
+		cli.AddObserver(batch.Observe)
+		dispatcher = batch.commandDispatcher
+	} else if editor != nil {
+		dispatcher = NewCommandDispatcher(cli, editor)
+		dispatcher.closeOnEOF = true
+		cli.SetDispatcher(dispatcher)
+	} else {
+		dispatcher = NewCommandDispatcher(cli, NewScannerReader(os.Stdin))
+		cli.SetDispatcher(dispatcher)
+	}
 
-	cli.AddHandler(enum.Ping, common.NewPingHandler(enum.Pong))
+	util.AssertNotError(cli.AddModule(common.NewHeartbeat(common.HeartbeatConfig{
+		PingCode: enum.Ping,
+		PongCode: enum.Pong,
+		Interval: time.Second * 15,
+		Timeout:  time.Minute,
+	})))
 	cli.AddHandler(enum.Display, common.NewDisplayHandler(
 		func(msg string) error {
-			log.Println(msg)
-			return nil
+			return display(out, msg)
+		}))
+	cli.AddHandler(common.ErrorMessageCode, common.NewErrorHandler(
+		func(msg *common.ErrorMessage) error {
+			_, err := fmt.Fprintf(out, "request %d failed: code=%d, message=%s\n",
+				msg.RequestID, msg.Code, msg.Message)
+			return err
 		}))
-	cli.AddHandler(enum.FileTransfer, NewFileTransferHandler(cli, time.Second*90))
-	util.AssertNotError(cli.Register(NewLoginCommand()))
-	util.AssertNotError(cli.Register(NewLogoutCommand()))
-	util.AssertNotError(cli.Register(NewGetUserListCommand()))
-	util.AssertNotError(cli.Register(NewSendCommand()))
+	cli.AddHandler(enum.CommandCatalog, common.NewTypedHandler(dispatcher.onCatalog))
+	chat := NewChatModule(cli, out)
+	util.AssertNotError(cli.AddModule(chat))
+	if editor != nil {
+		editor.SetCompleter(NewCompleter(dispatcher, chat.Users).Complete)
+		editor.SetHistoryFilter(func(line string) bool { return !dispatcher.secret(line) })
+		editor.SetPrompt("> ")
+	}
+	fileTransfer := NewFileTransferHandler(cli, time.Second*90)
+	fileTransfer.SetDownloadDir(profile.DownloadDir)
+	util.AssertNotError(cli.AddModule(fileTransfer))
+	if screen != nil {
+		view := &tuiView{screen: screen, client: cli, address: address, chat: chat, files: fileTransfer}
+		go view.run()
+	}
+	util.AssertNotError(cli.AddModule(&protocolModule{out: out}))
+	if profile.AutoLogin {
+		requestID := cli.SendMessage(&common.Message{
+			Code:    enum.UserLogin,
+			RawData: &msg.LoginMsg{NickName: profile.NickName, Password: profile.Password()},
+		})
+		if batch != nil {
+			batch.Track(requestID, "auto login")
+		}
+	}
+	if batch != nil {
+		go cli.Start()
+		os.Exit(batch.Wait())
+	}
 	cli.Start()
 }
+
+func scanAddress(editor *terminal.LineEditor, defaultAddress string) (string, error) {
+	editor.SetPrompt("address: ")
+	line, err := editor.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if len(strings.TrimSpace(line)) == 0 {
+		return defaultAddress, nil
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,432 @@
+package terminal
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrInterrupt 用户按下Ctrl-C
+var ErrInterrupt = errors.New("interrupt")
+
+// Completer 返回替换区间的起点和候选项，候选项替换line[start:pos]
+type Completer func(line []rune, pos int) (start int, candidates []string)
+
+// LineEditor 终端行编辑器，支持光标移动、历史、补全，
+// 通过Writer输出的内容会显示在输入行上方，不会打断正在输入的内容
+type LineEditor struct {
+	in        *os.File
+	reader    *bufio.Reader
+	out       io.Writer
+	history   *History
+	completer Completer
+	// keep 返回false的行不保存到历史
+	keep func(line string) bool
+
+	lock    sync.Mutex
+	prompt  string
+	buf     []rune
+	pos     int
+	active  bool
+	lastTab bool
+	restore func() error
+	// screen 非nil时为全屏模式，输入行固定在屏幕最后一行
+	screen *Screen
+}
+
+func NewLineEditor(in *os.File, out io.Writer, history *History) *LineEditor {
+	if history == nil {
+		history, _ = LoadHistory("", 1000)
+	}
+	return &LineEditor{
+		in:      in,
+		reader:  bufio.NewReader(in),
+		out:     out,
+		history: history,
+	}
+}
+
+func (e *LineEditor) SetCompleter(completer Completer) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.completer = completer
+}
+
+// SetHistoryFilter 设置哪些输入行保存到历史
+func (e *LineEditor) SetHistoryFilter(keep func(line string) bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.keep = keep
+}
+
+func (e *LineEditor) SetPrompt(prompt string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.prompt = prompt
+	if e.active {
+		e.redraw()
+	}
+}
+
+// Write 在输入行上方输出，输入行随后重绘
+func (e *LineEditor) Write(p []byte) (int, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.active {
+		return e.out.Write(p)
+	}
+	text := string(p)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	if _, err := io.WriteString(e.out, "\r\x1b[K"+text); err != nil {
+		return 0, err
+	}
+	e.redraw()
+	return len(p), nil
+}
+
+// ReadLine 读取一行，Ctrl-D在空行时返回io.EOF，Ctrl-C返回ErrInterrupt
+func (e *LineEditor) ReadLine() (string, error) {
+	restore, err := MakeRaw(int(e.in.Fd()))
+	if err != nil {
+		return "", err
+	}
+	e.lock.Lock()
+	e.restore = restore
+	e.buf, e.pos, e.active, e.lastTab = e.buf[:0], 0, true, false
+	e.redraw()
+	e.lock.Unlock()
+	historyIndex, draft := e.history.Len(), ""
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			e.finish("")
+			return "", err
+		}
+		e.lock.Lock()
+		tab := false
+		switch r {
+		case '\r', '\n':
+			line, keep := string(e.buf), e.keep
+			e.lock.Unlock()
+			e.finish("\n")
+			if keep == nil || keep(line) {
+				_ = e.history.Add(line)
+			}
+			return line, nil
+		case 3: // Ctrl-C
+			e.lock.Unlock()
+			e.finish("^C\n")
+			return "", ErrInterrupt
+		case 4: // Ctrl-D
+			if len(e.buf) == 0 {
+				e.lock.Unlock()
+				e.finish("\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case 127, 8: // Backspace
+			e.delete(e.pos-1, e.pos)
+		case 1: // Ctrl-A
+			e.pos = 0
+		case 5: // Ctrl-E
+			e.pos = len(e.buf)
+		case 2: // Ctrl-B
+			e.move(-1)
+		case 6: // Ctrl-F
+			e.move(1)
+		case 11: // Ctrl-K
+			e.delete(e.pos, len(e.buf))
+		case 21: // Ctrl-U
+			e.delete(0, e.pos)
+		case 23: // Ctrl-W
+			e.delete(e.wordStart(), e.pos)
+		case 12: // Ctrl-L
+			if e.screen != nil {
+				e.screen.Redraw()
+			} else {
+				_, _ = io.WriteString(e.out, "\x1b[H\x1b[2J")
+			}
+		case 16, 14: // Ctrl-P, Ctrl-N
+			historyIndex, draft = e.browse(r == 16, historyIndex, draft)
+		case '\t':
+			tab = true
+			e.complete()
+		case 27:
+			switch e.readEscape() {
+			case "[A", "OA":
+				historyIndex, draft = e.browse(true, historyIndex, draft)
+			case "[B", "OB":
+				historyIndex, draft = e.browse(false, historyIndex, draft)
+			case "[C", "OC":
+				e.move(1)
+			case "[D", "OD":
+				e.move(-1)
+			case "[H", "OH", "[1~", "[7~":
+				e.pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				e.pos = len(e.buf)
+			case "[3~":
+				e.delete(e.pos, e.pos+1)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				e.insert([]rune{r})
+			}
+		}
+		e.lastTab = tab
+		e.redraw()
+		e.lock.Unlock()
+	}
+}
+
+func (e *LineEditor) finish(suffix string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.screen != nil {
+		// 全屏模式下输入行不滚动，直接清空
+		_, _ = io.WriteString(e.out, "\r\x1b[K")
+	} else {
+		e.pos = len(e.buf)
+		e.redraw()
+		_, _ = io.WriteString(e.out, suffix)
+	}
+	e.active = false
+	e.buf = e.buf[:0]
+	e.pos = 0
+	_ = e.restoreTerminal()
+}
+
+// Refresh 重绘输入行
+func (e *LineEditor) Refresh() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.active {
+		e.redraw()
+	}
+}
+
+// Close 清除输入行并恢复终端模式，进程退出前必须调用，否则终端会停留在raw模式
+func (e *LineEditor) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.active {
+		_, _ = io.WriteString(e.out, "\r\x1b[K")
+		e.active = false
+	}
+	return e.restoreTerminal()
+}
+
+func (e *LineEditor) restoreTerminal() error {
+	if e.restore == nil {
+		return nil
+	}
+	err := e.restore()
+	e.restore = nil
+	return err
+}
+
+// readEscape 读取ESC之后的控制序列，只识别CSI和SS3
+func (e *LineEditor) readEscape() string {
+	r, _, err := e.reader.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return ""
+	}
+	sb := &strings.Builder{}
+	sb.WriteRune(r)
+	for {
+		r, _, err = e.reader.ReadRune()
+		if err != nil {
+			return ""
+		}
+		sb.WriteRune(r)
+		if r >= 0x40 && r <= 0x7e {
+			return sb.String()
+		}
+	}
+}
+
+func (e *LineEditor) insert(runes []rune) {
+	buf := make([]rune, 0, len(e.buf)+len(runes))
+	buf = append(buf, e.buf[:e.pos]...)
+	buf = append(buf, runes...)
+	buf = append(buf, e.buf[e.pos:]...)
+	e.buf = buf
+	e.pos += len(runes)
+}
+
+func (e *LineEditor) delete(from, to int) {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(e.buf) {
+		to = len(e.buf)
+	}
+	if from >= to {
+		return
+	}
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	if e.pos > to {
+		e.pos -= to - from
+	} else if e.pos > from {
+		e.pos = from
+	}
+}
+
+func (e *LineEditor) move(delta int) {
+	e.pos += delta
+	if e.pos < 0 {
+		e.pos = 0
+	}
+	if e.pos > len(e.buf) {
+		e.pos = len(e.buf)
+	}
+}
+
+func (e *LineEditor) wordStart() int {
+	i := e.pos
+	for i > 0 && e.buf[i-1] == ' ' {
+		i--
+	}
+	for i > 0 && e.buf[i-1] != ' ' {
+		i--
+	}
+	return i
+}
+
+// browse 在历史中移动，离开最新位置时保存正在编辑的内容
+func (e *LineEditor) browse(up bool, index int, draft string) (int, string) {
+	if index == e.history.Len() {
+		draft = string(e.buf)
+	}
+	if up && index > 0 {
+		index--
+	} else if !up && index < e.history.Len() {
+		index++
+	} else {
+		return index, draft
+	}
+	line := draft
+	if index < e.history.Len() {
+		line = e.history.At(index)
+	}
+	e.buf = []rune(line)
+	e.pos = len(e.buf)
+	return index, draft
+}
+
+// complete 唯一候选时直接补全，多个候选时补全公共前缀，连按两次Tab列出候选
+func (e *LineEditor) complete() {
+	if e.completer == nil {
+		return
+	}
+	start, candidates := e.completer(append([]rune(nil), e.buf...), e.pos)
+	if len(candidates) == 0 || start < 0 || start > e.pos {
+		return
+	}
+	current := string(e.buf[start:e.pos])
+	replacement := candidates[0]
+	if len(candidates) > 1 {
+		replacement = commonPrefix(candidates)
+	} else if !strings.HasSuffix(replacement, "/") {
+		replacement += " "
+	}
+	if replacement != current && strings.HasPrefix(replacement, current) {
+		e.delete(start, e.pos)
+		e.insert([]rune(replacement))
+		return
+	}
+	if len(candidates) > 1 && e.lastTab {
+		sorted := append([]string(nil), candidates...)
+		sort.Strings(sorted)
+		if e.screen != nil {
+			e.screen.Notice(strings.Join(sorted, "  "))
+		} else {
+			_, _ = io.WriteString(e.out, "\r\x1b[K"+strings.Join(sorted, "  ")+"\n")
+		}
+	}
+}
+
+func commonPrefix(candidates []string) string {
+	prefix := []rune(candidates[0])
+	for _, candidate := range candidates[1:] {
+		runes := []rune(candidate)
+		i := 0
+		for i < len(prefix) && i < len(runes) && prefix[i] == runes[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return string(prefix)
+}
+
+// redraw 重绘提示符和输入内容并把光标放到正确的位置，调用时需持有锁。
+// 输入超出一行时水平滚动，保证光标可见
+func (e *LineEditor) redraw() {
+	start, end := 0, len(e.buf)
+	if available := e.columns() - StringWidth(e.prompt) - 1; available > 0 {
+		for start < e.pos && StringWidth(string(e.buf[start:e.pos])) > available {
+			start++
+		}
+		end = start
+		for used := 0; end < len(e.buf) && used+RuneWidth(e.buf[end]) <= available; end++ {
+			used += RuneWidth(e.buf[end])
+		}
+	}
+	sb := &strings.Builder{}
+	sb.WriteString("\r\x1b[K")
+	sb.WriteString(e.prompt)
+	sb.WriteString(string(e.buf[start:end]))
+	if back := StringWidth(string(e.buf[e.pos:end])); back > 0 {
+		sb.WriteString("\x1b[" + strconv.Itoa(back) + "D")
+	}
+	_, _ = io.WriteString(e.out, sb.String())
+}
+
+// columns 终端宽度，未知时返回0
+func (e *LineEditor) columns() int {
+	if e.screen != nil {
+		return e.screen.Width()
+	}
+	width, _, err := GetSize(int(e.in.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+// StringWidth 字符串在终端上占用的列数，东亚宽字符占两列
+func StringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += RuneWidth(r)
+	}
+	return width
+}
+
+func RuneWidth(r rune) int {
+	switch {
+	case r == 0 || unicode.Is(unicode.Mn, r) || unicode.IsControl(r):
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	default:
+		return 1
+	}
+}
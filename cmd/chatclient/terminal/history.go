@@ -0,0 +1,95 @@
+package terminal
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// History 命令历史，设置了文件时每条命令追加写入文件
+type History struct {
+	lines []string
+	file  string
+	max   int
+}
+
+// LoadHistory file为空时只保存在内存中
+func LoadHistory(file string, max int) (*History, error) {
+	h := &History{file: file, max: max}
+	if len(file) == 0 {
+		return h, nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); len(line) != 0 {
+			h.lines = append(h.lines, line)
+		}
+	}
+	if len(h.lines) > max {
+		h.lines = h.lines[len(h.lines)-max:]
+		return h, h.rewrite()
+	}
+	return h, scanner.Err()
+}
+
+func (h *History) Len() int {
+	return len(h.lines)
+}
+
+func (h *History) At(i int) string {
+	return h.lines[i]
+}
+
+// Add 忽略空行和与上一条相同的命令
+func (h *History) Add(line string) error {
+	if len(strings.TrimSpace(line)) == 0 || strings.ContainsAny(line, "\r\n") {
+		return nil
+	}
+	if len(h.lines) != 0 && h.lines[len(h.lines)-1] == line {
+		return nil
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > h.max {
+		h.lines = h.lines[len(h.lines)-h.max:]
+		return h.rewrite()
+	}
+	if len(h.file) == 0 {
+		return nil
+	}
+	f, err := h.open(os.O_WRONLY | os.O_CREATE | os.O_APPEND)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+func (h *History) rewrite() error {
+	if len(h.file) == 0 {
+		return nil
+	}
+	f, err := h.open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(strings.Join(h.lines, "\n") + "\n")
+	return err
+}
+
+func (h *History) open(flag int) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(h.file), 0o700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(h.file, flag, 0o600)
+}
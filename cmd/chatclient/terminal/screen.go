@@ -0,0 +1,307 @@
+package terminal
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	maxScreenLines = 1000
+	sidebarWidth   = 24
+	// minSidebarScreenWidth 屏幕比这窄时不显示侧栏
+	minSidebarScreenWidth = 60
+)
+
+// Screen 全屏界面，使用终端的备用屏幕:
+//
+//	消息区                 │ 侧栏
+//	...                    │ ...
+//	状态栏
+//	输入行(由LineEditor负责)
+//
+// 写入Screen的内容按行追加到消息区，只显示最新的部分。
+type Screen struct {
+	file *os.File
+	out  *syncWriter
+
+	lock          sync.Mutex
+	width, height int
+	lines         []string
+	sidebarTitle  string
+	sidebar       []string
+	status        string
+	editor        *LineEditor
+	started       bool
+	resize        chan os.Signal
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// syncWriter 消息区和输入行在不同goroutine中重绘，保证每次输出不被打断
+type syncWriter struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.out.Write(p)
+}
+
+func NewScreen(file *os.File) *Screen {
+	return &Screen{
+		file:   file,
+		out:    &syncWriter{out: file},
+		resize: make(chan os.Signal, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// Output 输入行的输出，与Screen共享同一个锁
+func (s *Screen) Output() io.Writer {
+	return s.out
+}
+
+// Attach 输入行固定在最后一行，补全候选显示到消息区
+func (s *Screen) Attach(editor *LineEditor) {
+	s.lock.Lock()
+	s.editor = editor
+	s.lock.Unlock()
+	editor.lock.Lock()
+	editor.screen = s
+	editor.lock.Unlock()
+}
+
+// Start 切换到备用屏幕并开始跟踪窗口大小
+func (s *Screen) Start() error {
+	width, height, err := GetSize(int(s.file.Fd()))
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.width, s.height, s.started = width, height, true
+	_, _ = io.WriteString(s.out, "\x1b[?1049h\x1b[2J")
+	s.draw(true)
+	s.lock.Unlock()
+	NotifyResize(s.resize)
+	go s.watch()
+	return nil
+}
+
+// Close 回到普通屏幕，之后的写入直接输出
+func (s *Screen) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.started {
+			s.started = false
+			_, _ = io.WriteString(s.out, "\x1b[?1049l")
+		}
+	})
+	return nil
+}
+
+func (s *Screen) watch() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.resize:
+		}
+		width, height, err := GetSize(int(s.file.Fd()))
+		if err != nil {
+			continue
+		}
+		s.lock.Lock()
+		if !s.started {
+			s.lock.Unlock()
+			return
+		}
+		s.width, s.height = width, height
+		_, _ = io.WriteString(s.out, "\x1b[2J")
+		s.draw(true)
+		editor := s.editor
+		s.lock.Unlock()
+		// 不能持有Screen的锁调用editor，editor会在持有自己的锁时调用Screen
+		if editor != nil {
+			editor.Refresh()
+		}
+	}
+}
+
+// Write 追加到消息区
+func (s *Screen) Write(p []byte) (int, error) {
+	s.Notice(string(p))
+	return len(p), nil
+}
+
+// Notice 追加一段文字到消息区
+func (s *Screen) Notice(text string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.started {
+		_, _ = io.WriteString(s.out, text)
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		s.lines = append(s.lines, sanitize(line))
+	}
+	if len(s.lines) > maxScreenLines {
+		s.lines = append([]string(nil), s.lines[len(s.lines)-maxScreenLines:]...)
+	}
+	s.draw(false)
+}
+
+func (s *Screen) SetSidebar(title string, lines []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sidebarTitle == title && strings.Join(s.sidebar, "\n") == strings.Join(lines, "\n") {
+		return
+	}
+	s.sidebarTitle = title
+	s.sidebar = lines
+	s.draw(false)
+}
+
+func (s *Screen) SetStatus(status string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.status == status {
+		return
+	}
+	s.status = status
+	s.draw(false)
+}
+
+// Redraw 清屏后重绘全部区域，光标回到输入行
+func (s *Screen) Redraw() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.started {
+		return
+	}
+	_, _ = io.WriteString(s.out, "\x1b[2J")
+	s.draw(true)
+}
+
+// Width 输入行可用的宽度
+func (s *Screen) Width() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.width
+}
+
+// draw 重绘除输入行以外的区域，moveCursor为false时保存并恢复光标位置，调用时需持有锁
+func (s *Screen) draw(moveCursor bool) {
+	if !s.started || s.width <= 0 || s.height < 3 {
+		return
+	}
+	sideWidth := 0
+	if s.width >= minSidebarScreenWidth {
+		sideWidth = sidebarWidth
+	}
+	paneWidth := s.width
+	if sideWidth > 0 {
+		paneWidth = s.width - sideWidth - 1
+	}
+	rows := s.height - 2
+	var wrapped []string
+	for i := len(s.lines) - 1; i >= 0 && len(wrapped) < rows; i-- {
+		wrapped = append(wrap(s.lines[i], paneWidth), wrapped...)
+	}
+	if len(wrapped) > rows {
+		wrapped = wrapped[len(wrapped)-rows:]
+	}
+	side := append([]string{"\x00" + s.sidebarTitle}, s.sidebar...)
+
+	sb := &strings.Builder{}
+	if !moveCursor {
+		sb.WriteString("\x1b7")
+	}
+	for row := 0; row < rows; row++ {
+		sb.WriteString("\x1b[" + strconv.Itoa(row+1) + ";1H")
+		line := ""
+		if offset := rows - len(wrapped); row >= offset {
+			line = wrapped[row-offset]
+		}
+		sb.WriteString(pad(line, paneWidth))
+		if sideWidth == 0 {
+			continue
+		}
+		sb.WriteString("│")
+		if row < len(side) {
+			if strings.HasPrefix(side[row], "\x00") {
+				sb.WriteString("\x1b[1m" + pad(" "+side[row][1:], sideWidth) + "\x1b[0m")
+			} else {
+				sb.WriteString(pad(" "+side[row], sideWidth))
+			}
+		} else {
+			sb.WriteString(pad("", sideWidth))
+		}
+	}
+	sb.WriteString("\x1b[" + strconv.Itoa(s.height-1) + ";1H\x1b[7m")
+	sb.WriteString(pad(" "+s.status, s.width))
+	sb.WriteString("\x1b[0m")
+	if moveCursor {
+		sb.WriteString("\x1b[" + strconv.Itoa(s.height) + ";1H")
+	} else {
+		sb.WriteString("\x1b8")
+	}
+	_, _ = io.WriteString(s.out, sb.String())
+}
+
+// wrap 按显示宽度折行
+func wrap(line string, width int) []string {
+	if width <= 0 {
+		return nil
+	}
+	var lines []string
+	sb := &strings.Builder{}
+	used := 0
+	for _, r := range line {
+		w := RuneWidth(r)
+		if used+w > width {
+			lines = append(lines, sb.String())
+			sb.Reset()
+			used = 0
+		}
+		sb.WriteRune(r)
+		used += w
+	}
+	return append(lines, sb.String())
+}
+
+// pad 截断或用空格补齐到指定显示宽度
+func pad(s string, width int) string {
+	sb := &strings.Builder{}
+	used := 0
+	for _, r := range s {
+		w := RuneWidth(r)
+		if used+w > width {
+			break
+		}
+		sb.WriteRune(r)
+		used += w
+	}
+	if used < width {
+		sb.WriteString(strings.Repeat(" ", width-used))
+	}
+	return sb.String()
+}
+
+// sanitize 去掉会破坏布局的控制字符
+func sanitize(line string) string {
+	line = strings.ReplaceAll(line, "\t", "    ")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, line)
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package terminal
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("terminal is not supported on this platform")
+
+func IsTerminal(_ int) bool {
+	return false
+}
+
+func MakeRaw(_ int) (func() error, error) {
+	return nil, errUnsupported
+}
+
+func GetSize(_ int) (width, height int, err error) {
+	return 0, 0, errUnsupported
+}
+
+func NotifyResize(_ chan<- os.Signal) {}
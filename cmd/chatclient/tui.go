@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"gochat/cmd/chatclient/terminal"
+	"gochat/goclient"
+	"strings"
+	"time"
+)
+
+// tuiView 全屏模式下定时把在线用户和连接、文件传输状态刷新到侧栏和状态栏，
+// 消息区的内容来自logger
+type tuiView struct {
+	screen  *terminal.Screen
+	client  *goclient.Client
+	address string
+	chat    *chatModule
+	files   *fileTransferHandler
+}
+
+func (v *tuiView) run() {
+	ticker := time.NewTicker(time.Millisecond * 500)
+	defer ticker.Stop()
+	for {
+		v.refresh(false)
+		select {
+		case <-v.client.Done():
+			v.refresh(true)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (v *tuiView) refresh(closed bool) {
+	users := v.chat.Users()
+	lines := make([]string, 0, len(users))
+	for _, user := range users {
+		lines = append(lines, fmt.Sprintf("%s (%s)", user.NickName, shortID(user.ID)))
+	}
+	v.screen.SetSidebar(fmt.Sprintf("online (%d)", len(users)), lines)
+
+	parts := []string{"connected " + v.address}
+	if closed {
+		parts[0] = "disconnected"
+	}
+	for _, transfer := range v.files.Transfers() {
+		parts = append(parts, formatTransfer(transfer))
+	}
+	v.screen.SetStatus(strings.Join(parts, " | "))
+}
+
+func formatTransfer(transfer fileTransferStatus) string {
+	direction := "recv " + transfer.FileName + " from " + shortID(transfer.Peer)
+	if transfer.Sending {
+		direction = "send " + transfer.FileName + " to " + shortID(transfer.Peer)
+	}
+	if transfer.Waiting {
+		return direction + " waiting"
+	}
+	percent := int64(100)
+	if transfer.Size > 0 {
+		percent = transfer.Done * 100 / transfer.Size
+	}
+	return fmt.Sprintf("%s %d%% (%d/%d)", direction, percent, transfer.Done, transfer.Size)
+}
+
+// shortID 界面上只显示ID的前6位
+func shortID(id string) string {
+	if len(id) > 6 {
+		return id[:6]
+	}
+	return id
+}
@@ -0,0 +1,207 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"gochat/cmd/chatserver/handler"
+	"gochat/common"
+	"gochat/goserver"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UserManager 管理在线用户
+type UserManager interface {
+	GetOnlineUsers(limit int) []*handler.OnlineUser
+	OnlineUserCount() int
+	Kick(id, reason string) error
+	Announce(text string)
+}
+
+type Config struct {
+	// Token 请求需携带 Authorization: Bearer <Token>
+	Token  string
+	Server *goserver.Server
+	Users  UserManager
+	// Stats 返回附加到/api/stats的统计项，可为空
+	Stats  func() map[string]interface{}
+	Logger common.Logger
+}
+
+type UserInfo struct {
+	ID       string    `json:"id"`
+	NickName string    `json:"nickname"`
+	Addr     string    `json:"addr"`
+	LoginAt  time.Time `json:"login_at"`
+}
+
+type StatsInfo struct {
+	goserver.Stats
+	OnlineUsers int                    `json:"online_users"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+}
+
+type kickRequest struct {
+	Reason string `json:"reason"`
+}
+
+type broadcastRequest struct {
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// API 只读接口用GET，修改接口用POST
+//
+//	GET  /api/users
+//	POST /api/users/{id}/kick   {"reason": "..."}
+//	GET  /api/connections
+//	POST /api/broadcast         {"message": "..."}
+//	GET  /api/stats
+type API struct {
+	config Config
+	mux    *http.ServeMux
+}
+
+func NewAPI(config Config) (*API, error) {
+	if len(config.Token) == 0 {
+		return nil, errors.New("admin token is required")
+	}
+	if config.Server == nil || config.Users == nil {
+		return nil, errors.New("admin api requires server and users")
+	}
+	if config.Logger == nil {
+		config.Logger = config.Server.Logger()
+	}
+	a := &API{config: config, mux: http.NewServeMux()}
+	a.mux.HandleFunc("/api/users", a.method(http.MethodGet, a.listUsers))
+	a.mux.HandleFunc("/api/users/", a.method(http.MethodPost, a.kickUser))
+	a.mux.HandleFunc("/api/connections", a.method(http.MethodGet, a.listConnections))
+	a.mux.HandleFunc("/api/broadcast", a.method(http.MethodPost, a.broadcast))
+	a.mux.HandleFunc("/api/stats", a.method(http.MethodGet, a.stats))
+	return a, nil
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gochat-admin"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *API) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(a.config.Token)) == 1
+}
+
+func (a *API) method(method string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		f(w, r)
+	}
+}
+
+func (a *API) listUsers(w http.ResponseWriter, _ *http.Request) {
+	users := a.config.Users.GetOnlineUsers(1000)
+	infos := make([]UserInfo, 0, len(users))
+	for _, user := range users {
+		infos = append(infos, UserInfo{
+			ID:       user.ID(),
+			NickName: user.NikeName(),
+			Addr:     user.Addr(),
+			LoginAt:  user.LoginAt(),
+		})
+	}
+	writeJson(w, http.StatusOK, infos)
+}
+
+func (a *API) kickUser(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if !strings.HasSuffix(path, "/kick") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	id := strings.TrimSuffix(path, "/kick")
+	if len(id) == 0 || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	request := &kickRequest{}
+	if !readJson(w, r, request) {
+		return
+	}
+	if err := a.config.Users.Kick(id, request.Reason); err != nil {
+		if codeErr, ok := common.AsCodeError(err); ok && codeErr.Code == common.ErrCodeNotFound {
+			writeError(w, http.StatusNotFound, codeErr.Message)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.config.Logger.Info("admin kicked user", common.F("user", id), common.F("reason", request.Reason))
+	writeJson(w, http.StatusOK, map[string]string{"kicked": id})
+}
+
+func (a *API) listConnections(w http.ResponseWriter, _ *http.Request) {
+	writeJson(w, http.StatusOK, a.config.Server.Connections())
+}
+
+func (a *API) broadcast(w http.ResponseWriter, r *http.Request) {
+	request := &broadcastRequest{}
+	if !readJson(w, r, request) {
+		return
+	}
+	if len(strings.TrimSpace(request.Message)) == 0 {
+		writeError(w, http.StatusBadRequest, "missing required field message")
+		return
+	}
+	a.config.Users.Announce(request.Message)
+	a.config.Logger.Info("admin broadcast", common.F("message", request.Message))
+	writeJson(w, http.StatusOK, map[string]int{"recipients": a.config.Users.OnlineUserCount()})
+}
+
+func (a *API) stats(w http.ResponseWriter, _ *http.Request) {
+	info := StatsInfo{
+		Stats:       a.config.Server.Stats(),
+		OnlineUsers: a.config.Users.OnlineUserCount(),
+	}
+	if a.config.Stats != nil {
+		info.Extra = a.config.Stats()
+	}
+	writeJson(w, http.StatusOK, info)
+}
+
+// readJson 请求体为空时保持默认值
+func readJson(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+	if err := decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return false
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, errorResponse{Error: message})
+}
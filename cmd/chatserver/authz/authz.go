@@ -0,0 +1,101 @@
+package authz
+
+import (
+	"fmt"
+	"gochat/common"
+	"gochat/goserver"
+)
+
+// methodGuest 访客登录的认证方式
+const methodGuest = "guest"
+
+// 内置角色，访客只有RoleGuest，其他登录方式都有RoleUser，RoleAdmin可以使用所有消息码
+const (
+	RoleGuest     = "guest"
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Policy 给认证得到的身份分配角色，并在消息交给handler之前按消息码检查角色
+type Policy struct {
+	// roles 按昵称追加的角色
+	roles map[string][]string
+	// codes 列出的消息码只有拥有其中任一角色的身份可以发送
+	codes map[common.MessageCode][]string
+}
+
+// NewPolicy rules的key为消息码名或命令名，命令名按commands解析为消息码
+func NewPolicy(roles, rules map[string][]string, commands []common.CommandSpec) (*Policy, error) {
+	names := make(map[string]common.MessageCode, len(commands))
+	for _, command := range commands {
+		names[command.Name] = command.Code
+		for _, alias := range command.Alias {
+			names[alias] = command.Code
+		}
+	}
+	p := &Policy{roles: roles, codes: make(map[common.MessageCode][]string, len(rules))}
+	for name, allowed := range rules {
+		code, ok := names[name]
+		if info, found := common.LookupCodeByName(name); found {
+			code, ok = info.Code, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("policy %q is neither a message code nor a command", name)
+		}
+		p.codes[code] = append(p.codes[code], allowed...)
+	}
+	return p, nil
+}
+
+// Roles 访客为guest，其他身份为user加上配置中该昵称的角色
+func (p *Policy) Roles(principal *common.Principal) []string {
+	if principal.Method == methodGuest {
+		return []string{RoleGuest}
+	}
+	return append([]string{RoleUser}, p.roles[principal.Name]...)
+}
+
+func (p *Policy) Authorize(_ common.Context, principal *common.Principal, message *common.RawMessage) error {
+	allowed, ok := p.codes[message.Code]
+	if !ok {
+		return nil
+	}
+	if principal == nil {
+		return common.ErrUnauthenticated
+	}
+	if principal.HasRole(RoleAdmin) {
+		return nil
+	}
+	for _, role := range allowed {
+		if principal.HasRole(role) {
+			return nil
+		}
+	}
+	return common.CodeErrorf(common.ErrCodeForbidden, "permission denied: %s requires role %v", message.Code, allowed)
+}
+
+// Authenticator 包装authenticator，给认证得到的身份分配角色
+func (p *Policy) Authenticator(authenticator goserver.Authenticator) goserver.Authenticator {
+	return &roleAuthenticator{Authenticator: authenticator, policy: p}
+}
+
+type roleAuthenticator struct {
+	goserver.Authenticator
+	policy *Policy
+}
+
+func (a *roleAuthenticator) Handshake(info *goserver.HandshakeInfo) (*common.Principal, error) {
+	return a.assign(a.Authenticator.Handshake(info))
+}
+
+func (a *roleAuthenticator) Message(ctx common.Context, message *common.RawMessage) (*common.Principal, error) {
+	return a.assign(a.Authenticator.Message(ctx, message))
+}
+
+func (a *roleAuthenticator) assign(principal *common.Principal, err error) (*common.Principal, error) {
+	if principal != nil && err == nil {
+		principal.Roles = a.policy.Roles(principal)
+	}
+	return principal, err
+}
@@ -0,0 +1,362 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gochat/common"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// EnvPrefix 环境变量名为前缀加上大写的flag名，"-"换成"_"，例如GOCHAT_LOG_LEVEL
+const EnvPrefix = "GOCHAT_"
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile 非空时要求客户端提供由该CA签发的证书
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return len(c.CertFile) != 0 || len(c.KeyFile) != 0
+}
+
+// Load 未启用TLS时返回nil
+func (c TLSConfig) Load() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls key pair error: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(c.ClientCAFile) != 0 {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca error: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+type LimitsConfig struct {
+	// MaxConnections 0表示不限制
+	MaxConnections   int      `json:"max_connections"`
+	HandshakeTimeout Duration `json:"handshake_timeout"`
+	// HandlerTimeout 单条消息的默认处理超时，0表示不限制
+	HandlerTimeout Duration `json:"handler_timeout"`
+	ShutdownDelay  Duration `json:"shutdown_delay"`
+}
+
+type HeartbeatConfig struct {
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+}
+
+type StorageConfig struct {
+	// DataDir 持久化数据所在目录，为空时数据只保存在内存中
+	DataDir string `json:"data_dir"`
+	// History 聊天记录的保存方式: file保存到DataDir下，memory只保存在内存中。
+	// DataDir为空时总是保存在内存中
+	History string `json:"history"`
+	// HistoryReplay 登录或加入聊天室时发送的历史消息数，0表示不发送
+	HistoryReplay int `json:"history_replay"`
+	// InboxLimit 每个用户最多保存的离线消息数，InboxTTL 离线消息的保存时间，0表示不限制
+	InboxLimit int      `json:"inbox_limit"`
+	InboxTTL   Duration `json:"inbox_ttl"`
+}
+
+type AuthConfig struct {
+	// RequireAccount 为true时只有注册用户可以登录
+	RequireAccount bool `json:"require_account"`
+	// Tokens 握手时可以使用的令牌，使用令牌的连接以对应的昵称登录
+	Tokens []TokenConfig `json:"tokens"`
+	// Roles 按昵称给注册用户、令牌和证书登录的用户追加角色，例如moderator、admin
+	Roles map[string][]string `json:"roles"`
+	// Policy 以消息码名或命令名为key，列出可以使用的角色，未列出的不限制，admin总能使用。
+	// 配置文件中没有policy时使用DefaultPolicy，写成{}表示不限制
+	Policy map[string][]string `json:"policy"`
+}
+
+type TokenConfig struct {
+	Token string `json:"token"`
+	Name  string `json:"name"`
+}
+
+type AdminConfig struct {
+	Address string `json:"address"`
+	Token   string `json:"token"`
+}
+
+type Config struct {
+	Address        string          `json:"address"`
+	TLS            TLSConfig       `json:"tls"`
+	Log            LogConfig       `json:"log"`
+	Limits         LimitsConfig    `json:"limits"`
+	Heartbeat      HeartbeatConfig `json:"heartbeat"`
+	Storage        StorageConfig   `json:"storage"`
+	Auth           AuthConfig      `json:"auth"`
+	MetricsAddress string          `json:"metrics_address"`
+	DebugAddress   string          `json:"debug_address"`
+	Admin          AdminConfig     `json:"admin"`
+	// Modules 按模块名启用或停用，未出现的模块保持启用
+	Modules map[string]bool `json:"modules"`
+}
+
+// DefaultPolicy 只有moderator可以踢人
+func DefaultPolicy() map[string][]string {
+	return map[string][]string{"kick": {"moderator"}}
+}
+
+func Default() *Config {
+	return &Config{
+		Address: "localhost:8080",
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Limits: LimitsConfig{
+			HandshakeTimeout: Duration(common.DefaultHandshakeTimeout),
+		},
+		Heartbeat: HeartbeatConfig{
+			Interval: Duration(time.Second * 15),
+			Timeout:  Duration(time.Minute),
+		},
+		Storage: StorageConfig{
+			History:       "file",
+			HistoryReplay: 20,
+			InboxLimit:    100,
+			InboxTTL:      Duration(time.Hour * 24 * 7),
+		},
+		Auth: AuthConfig{
+			Roles: make(map[string][]string),
+		},
+		Modules: make(map[string]bool),
+	}
+}
+
+type setting struct {
+	name  string
+	usage string
+	value func(c *Config) flag.Value
+}
+
+// settings 同时定义flag和环境变量
+var settings = []setting{
+	{"address", "listen address", func(c *Config) flag.Value { return stringValue{&c.Address} }},
+	{"tls-cert", "tls certificate file, enables tls together with -tls-key", func(c *Config) flag.Value { return stringValue{&c.TLS.CertFile} }},
+	{"tls-key", "tls private key file", func(c *Config) flag.Value { return stringValue{&c.TLS.KeyFile} }},
+	{"tls-client-ca", "require client certificates signed by this ca", func(c *Config) flag.Value { return stringValue{&c.TLS.ClientCAFile} }},
+	{"log-level", "log level: debug, info, error", func(c *Config) flag.Value { return stringValue{&c.Log.Level} }},
+	{"log-format", "log format: text, json", func(c *Config) flag.Value { return stringValue{&c.Log.Format} }},
+	{"max-connections", "max open connections, 0 for unlimited", func(c *Config) flag.Value { return intValue{&c.Limits.MaxConnections} }},
+	{"handshake-timeout", "time allowed for the client handshake", func(c *Config) flag.Value { return durationValue{&c.Limits.HandshakeTimeout} }},
+	{"handler-timeout", "default message handler timeout, 0 for unlimited", func(c *Config) flag.Value { return durationValue{&c.Limits.HandlerTimeout} }},
+	{"shutdown-delay", "report not ready for this long before shutting down", func(c *Config) flag.Value { return durationValue{&c.Limits.ShutdownDelay} }},
+	{"heartbeat-interval", "interval between pings", func(c *Config) flag.Value { return durationValue{&c.Heartbeat.Interval} }},
+	{"heartbeat-timeout", "close connections silent for this long", func(c *Config) flag.Value { return durationValue{&c.Heartbeat.Timeout} }},
+	{"data-dir", "directory for persistent data, empty to keep data in memory", func(c *Config) flag.Value { return stringValue{&c.Storage.DataDir} }},
+	{"history", "message history storage: file, memory", func(c *Config) flag.Value { return stringValue{&c.Storage.History} }},
+	{"history-replay", "messages replayed on login and join, 0 to disable", func(c *Config) flag.Value { return intValue{&c.Storage.HistoryReplay} }},
+	{"inbox-limit", "max offline messages kept per user, 0 for unlimited", func(c *Config) flag.Value { return intValue{&c.Storage.InboxLimit} }},
+	{"inbox-ttl", "drop offline messages older than this, 0 to keep forever", func(c *Config) flag.Value { return durationValue{&c.Storage.InboxTTL} }},
+	{"require-account", "only registered users can login", func(c *Config) flag.Value { return boolValue{&c.Auth.RequireAccount} }},
+	{"metrics-address", "serve prometheus metrics on this address, empty to disable", func(c *Config) flag.Value { return stringValue{&c.MetricsAddress} }},
+	{"debug-address", "serve /healthz, /readyz and pprof on this address, empty to disable", func(c *Config) flag.Value { return stringValue{&c.DebugAddress} }},
+	{"admin-address", "serve admin http api on this address, empty to disable", func(c *Config) flag.Value { return stringValue{&c.Admin.Address} }},
+	{"admin-token", "bearer token required by the admin api", func(c *Config) flag.Value { return stringValue{&c.Admin.Token} }},
+	{"modules", "enable or disable modules, e.g. filetransfer=false", func(c *Config) flag.Value { return modulesValue{&c.Modules} }},
+}
+
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Options 命令行解析结果
+type Options struct {
+	Config      *Config
+	ConfigFile  string
+	PrintConfig bool
+}
+
+func newFlagSet(name string, c *Config, options *Options, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&options.ConfigFile, "config", os.Getenv(envName("config")), "json config file")
+	fs.BoolVar(&options.PrintConfig, "print-config", false, "print the effective config and exit")
+	for _, s := range settings {
+		fs.Var(s.value(c), s.name, s.usage+" (env "+envName(s.name)+")")
+	}
+	return fs
+}
+
+// Load 按默认值、配置文件、环境变量、命令行的顺序覆盖配置并校验
+func Load(name string, args []string, output io.Writer) (*Options, error) {
+	// 第一遍只为了拿到配置文件路径
+	options := &Options{}
+	if err := newFlagSet(name, Default(), options, output).Parse(args); err != nil {
+		return nil, err
+	}
+	c := Default()
+	if len(options.ConfigFile) != 0 {
+		if err := c.loadFile(options.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(envName(s.name)); ok {
+			if err := s.value(c).Set(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", envName(s.name), err)
+			}
+		}
+	}
+	if err := newFlagSet(name, c, options, ioutil.Discard).Parse(args); err != nil {
+		return nil, err
+	}
+	// 在Default中设置会和配置文件中的policy合并，无法去掉默认规则
+	if c.Auth.Policy == nil {
+		c.Auth.Policy = DefaultPolicy()
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	options.Config = c
+	return options, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file error: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("parse config file %s error: %w", path, err)
+	}
+	if c.Modules == nil {
+		c.Modules = make(map[string]bool)
+	}
+	return nil
+}
+
+// Validate 返回所有不合法的配置项
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	checkAddress := func(name, address string, required bool) {
+		if len(address) == 0 {
+			check(!required, "%s is required", name)
+			return
+		}
+		_, _, err := net.SplitHostPort(address)
+		check(err == nil, "invalid %s %q", name, address)
+	}
+	checkFile := func(name, path string) {
+		if len(path) == 0 {
+			return
+		}
+		_, err := os.Stat(path)
+		check(err == nil, "%s %s is not readable", name, path)
+	}
+	checkAddress("address", c.Address, true)
+	checkAddress("metrics_address", c.MetricsAddress, false)
+	checkAddress("debug_address", c.DebugAddress, false)
+	checkAddress("admin.address", c.Admin.Address, false)
+	check(len(c.Admin.Address) == 0 || len(c.Admin.Token) != 0, "admin.token is required when admin.address is set")
+	check((len(c.TLS.CertFile) != 0) == (len(c.TLS.KeyFile) != 0), "tls.cert_file and tls.key_file must be set together")
+	check(len(c.TLS.ClientCAFile) == 0 || c.TLS.Enabled(), "tls.client_ca_file requires tls.cert_file and tls.key_file")
+	tokens := make(map[string]bool, len(c.Auth.Tokens))
+	for i, token := range c.Auth.Tokens {
+		check(len(token.Token) != 0 && len(token.Name) != 0, "auth.tokens[%d] requires token and name", i)
+		check(!tokens[token.Token], "auth.tokens[%d] is duplicated", i)
+		tokens[token.Token] = true
+	}
+	for name, roles := range c.Auth.Roles {
+		for _, role := range roles {
+			check(len(role) != 0, "auth.roles of %s contains an empty role", name)
+		}
+	}
+	checkFile("tls.cert_file", c.TLS.CertFile)
+	checkFile("tls.key_file", c.TLS.KeyFile)
+	checkFile("tls.client_ca_file", c.TLS.ClientCAFile)
+	_, err := common.ParseLogLevel(c.Log.Level)
+	check(err == nil, "invalid log.level %q", c.Log.Level)
+	_, err = common.ParseLogFormat(c.Log.Format)
+	check(err == nil, "invalid log.format %q", c.Log.Format)
+	check(c.Limits.MaxConnections >= 0, "limits.max_connections must not be negative")
+	check(c.Limits.HandshakeTimeout >= 0, "limits.handshake_timeout must not be negative")
+	check(c.Limits.HandlerTimeout >= 0, "limits.handler_timeout must not be negative")
+	check(c.Limits.ShutdownDelay >= 0, "limits.shutdown_delay must not be negative")
+	check(c.Storage.History == "file" || c.Storage.History == "memory", "invalid storage.history %q", c.Storage.History)
+	check(c.Storage.HistoryReplay >= 0, "storage.history_replay must not be negative")
+	check(c.Storage.InboxLimit >= 0, "storage.inbox_limit must not be negative")
+	check(c.Storage.InboxTTL >= 0, "storage.inbox_ttl must not be negative")
+	check(c.Heartbeat.Interval > 0, "heartbeat.interval must be positive")
+	check(c.Heartbeat.Timeout > c.Heartbeat.Interval, "heartbeat.timeout must be greater than heartbeat.interval")
+	if len(problems) != 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ValidateModules 检查配置中的模块名都存在
+func (c *Config) ValidateModules(known []string) error {
+	names := make(map[string]bool, len(known))
+	for _, name := range known {
+		names[name] = true
+	}
+	for name := range c.Modules {
+		if !names[name] {
+			return fmt.Errorf("invalid config: unknown module %q, known modules: %s", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// Print 以JSON输出配置，敏感字段会被隐藏
+func (c *Config) Print(w io.Writer) error {
+	printed := *c
+	if len(printed.Admin.Token) != 0 {
+		printed.Admin.Token = "******"
+	}
+	printed.Auth.Tokens = make([]TokenConfig, len(c.Auth.Tokens))
+	for i, token := range c.Auth.Tokens {
+		printed.Auth.Tokens[i] = TokenConfig{Token: "******", Name: token.Name}
+	}
+	data, err := json.MarshalIndent(&printed, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
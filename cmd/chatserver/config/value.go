@@ -0,0 +1,157 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration 在配置文件中使用"15s"、"1m"这样的字符串
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"15s\": %w", err)
+	}
+	return d.Set(s)
+}
+
+func (d *Duration) Set(s string) error {
+	duration, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+type stringValue struct {
+	p *string
+}
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v stringValue) Set(s string) error {
+	*v.p = s
+	return nil
+}
+
+type intValue struct {
+	p *int
+}
+
+func (v intValue) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*v.p)
+}
+
+func (v intValue) Set(s string) error {
+	i, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", s)
+	}
+	*v.p = i
+	return nil
+}
+
+// boolValue 可以只写-name表示true
+type boolValue struct {
+	p *bool
+}
+
+func (v boolValue) String() string {
+	if v.p == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*v.p)
+}
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid bool %q", s)
+	}
+	*v.p = b
+	return nil
+}
+
+func (v boolValue) IsBoolFlag() bool {
+	return true
+}
+
+type durationValue struct {
+	p *Duration
+}
+
+func (v durationValue) String() string {
+	if v.p == nil {
+		return "0s"
+	}
+	return v.p.String()
+}
+
+func (v durationValue) Set(s string) error {
+	return v.p.Set(s)
+}
+
+// modulesValue 解析"filetransfer=false,chat=true"，只覆盖出现的模块
+type modulesValue struct {
+	p *map[string]bool
+}
+
+func (v modulesValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*v.p))
+	for name := range *v.p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.FormatBool((*v.p)[name]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v modulesValue) Set(s string) error {
+	if *v.p == nil {
+		*v.p = make(map[string]bool)
+	}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		name, value := pair, "true"
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name, value = strings.TrimSpace(pair[:i]), pair[i+1:]
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil || len(name) == 0 {
+			return fmt.Errorf("invalid module setting %q, want name=true|false", pair)
+		}
+		(*v.p)[name] = enabled
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"errors"
+	"gochat/cmd/chatserver/store"
+	"gochat/common"
+	"gochat/common/message/msg"
+	"gochat/common/util"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxNickNameLength = 32
+	minPasswordLength = 8
+)
+
+// errLoginFailed 不区分用户不存在和密码错误
+var errLoginFailed = common.NewCodeError(common.ErrCodeUnauthorized, "invalid nickname or password")
+
+// 登录消息认证得到的身份的认证方式，登出时会取消这两种认证
+const (
+	methodPassword = "password"
+	methodGuest    = "guest"
+)
+
+// AuthenticateLogin 用登录消息中的昵称和密码认证连接，作为goserver.MessageAuthenticator使用
+func (h *userHandler) AuthenticateLogin(ctx common.Context, message *common.RawMessage) (*common.Principal, error) {
+	login := &msg.LoginMsg{}
+	if err := ctx.Unmarshal(message.RawData, login); err != nil {
+		return nil, common.NewCodeError(common.ErrCodeBadRequest, "invalid data")
+	}
+	if err := common.ValidateRequired(login); err != nil {
+		return nil, err
+	}
+	return h.authenticate(ctx, login.NickName, login.Password)
+}
+
+// authenticate 已注册的昵称校验密码并使用账号ID，未注册的昵称作为访客登录
+func (h *userHandler) authenticate(ctx common.Context, name, password string) (*common.Principal, error) {
+	name = normalizeNickName(name)
+	if err := validNickName(name); err != nil {
+		return nil, err
+	}
+	account, ok, err := h.config.Users.Get(name)
+	if err != nil {
+		ctx.Logger().Error("load account error", common.Err(err))
+		return nil, common.NewCodeError(common.ErrCodeInternal, "login error")
+	}
+	if !ok {
+		if h.config.RequireAccount {
+			return nil, common.NewCodeError(common.ErrCodeUnauthorized, "please register first")
+		}
+		if len(password) != 0 {
+			return nil, errLoginFailed
+		}
+		return &common.Principal{Name: name, Method: methodGuest}, nil
+	}
+	if !account.Password.Verify(password) {
+		return nil, errLoginFailed
+	}
+	return &common.Principal{ID: account.ID, Name: account.Name, Method: methodPassword}, nil
+}
+
+// bindPrincipal 用连接的身份填充用户，身份没有ID时按昵称查找账号，
+// 令牌或证书认证的昵称未注册时作为访客
+func (h *userHandler) bindPrincipal(user *OnlineUser, principal *common.Principal) error {
+	if err := validNickName(principal.Name); err != nil {
+		return err
+	}
+	user.user.NickName = principal.Name
+	if len(principal.ID) != 0 {
+		user.id, user.account = principal.ID, principal.Name
+		return nil
+	}
+	account, ok, err := h.config.Users.Get(principal.Name)
+	if err != nil {
+		user.ctx.Logger().Error("load account error", common.Err(err))
+		return common.NewCodeError(common.ErrCodeInternal, "login error")
+	}
+	if ok {
+		user.id, user.account = account.ID, account.Name
+		return nil
+	}
+	user.id = user.Session()
+	return nil
+}
+
+// normalizeNickName 去掉首尾空白，注册和登录前都要调用
+func normalizeNickName(name string) string {
+	return strings.TrimSpace(name)
+}
+
+// validNickName 昵称不能包含空白、控制字符和不可见的格式字符，注册和所有登录方式都要校验，
+// 避免用相似的昵称冒充其他用户
+func validNickName(name string) error {
+	if len(name) == 0 || utf8.RuneCountInString(name) > maxNickNameLength {
+		return common.CodeErrorf(common.ErrCodeBadRequest, "nickname must be 1-%d characters", maxNickNameLength)
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return common.CodeErrorf(common.ErrCodeBadRequest, "invalid nickname %q", name)
+		}
+	}
+	return nil
+}
+
+func validPassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return common.CodeErrorf(common.ErrCodeBadRequest, "password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+func (h *userHandler) register(ctx common.Context, message *msg.RegisterMsg) error {
+	message.NickName = normalizeNickName(message.NickName)
+	if err := validNickName(message.NickName); err != nil {
+		return err
+	}
+	if err := validPassword(message.Password); err != nil {
+		return err
+	}
+	account, err := store.NewAccount(message.NickName, message.Password)
+	if err != nil {
+		ctx.Logger().Error("create account error", common.Err(err))
+		return common.NewCodeError(common.ErrCodeInternal, "register error")
+	}
+	if err := h.config.Users.Create(account); err != nil {
+		if errors.Is(err, store.ErrUserExists) {
+			return common.CodeErrorf(common.ErrCodeConflict, "nickname %s is already registered", message.NickName)
+		}
+		ctx.Logger().Error("save account error", common.Err(err))
+		return common.NewCodeError(common.ErrCodeInternal, "register error")
+	}
+	if err := h.config.Inbox.Register(account.Name); err != nil {
+		ctx.Logger().Error("register inbox error", common.Err(err))
+	}
+	ctx.Logger().Info("account registered", common.F("account", account.ID))
+	return ctx.Write(util.NewDisplayMessage("register success, now login with your nickname and password"))
+}
+
+// changePassword 访客没有密码可改
+func (h *userHandler) changePassword(ctx common.Context, message *msg.ChangePasswordMsg) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if !user.Registered() {
+		return common.NewCodeError(common.ErrCodeForbidden, "guests have no password, please register")
+	}
+	account, ok, err := h.config.Users.Get(user.account)
+	if err != nil || !ok {
+		return common.NewCodeError(common.ErrCodeInternal, "load account error")
+	}
+	if !account.Password.Verify(message.OldPassword) {
+		return common.NewCodeError(common.ErrCodeUnauthorized, "wrong password")
+	}
+	if err := validPassword(message.NewPassword); err != nil {
+		return err
+	}
+	if account.Password, err = store.HashPassword(message.NewPassword); err != nil {
+		return common.NewCodeError(common.ErrCodeInternal, "change password error")
+	}
+	if err := h.config.Users.Update(account); err != nil {
+		ctx.Logger().Error("save account error", common.Err(err))
+		return common.NewCodeError(common.ErrCodeInternal, "change password error")
+	}
+	return ctx.Write(util.NewDisplayMessage("password changed"))
+}
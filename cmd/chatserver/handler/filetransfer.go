@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"fmt"
+	"gochat/common"
+	"gochat/common/message/enum"
+	"gochat/common/message/msg"
+	"gochat/common/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FileTransferModuleName = "filetransfer"
+	// transferIdleTimeout 超过该时间没有消息的传输不再计为进行中
+	transferIdleTimeout = time.Minute * 2
+)
+
+// fileTransferModule 在两个在线用户之间转发文件传输消息
+type fileTransferModule struct {
+	common.BaseModule
+	uh         *userHandler
+	handlerMap map[common.MessageCode]common.Handler
+	lock       sync.Mutex
+	transfers  map[string]time.Time
+	// sessions 以发送方ID->接收方ID为key记录发送方使用的连接，
+	// 同一用户有多个连接时回复发到参与传输的那个连接
+	sessions map[string]string
+}
+
+func NewFileTransferModule(uh *userHandler) *fileTransferModule {
+	h := &fileTransferModule{uh: uh, transfers: make(map[string]time.Time), sessions: make(map[string]string)}
+	h.handlerMap = map[common.MessageCode]common.Handler{
+		enum.FileTransfer: &fileTransferHandler{Handler: common.NewTypedHandler(h.fileTransfer), m: h},
+	}
+	return h
+}
+
+func (h *fileTransferModule) Name() string {
+	return FileTransferModuleName
+}
+
+func (h *fileTransferModule) Dependencies() []string {
+	return []string{ChatModuleName}
+}
+
+func (h *fileTransferModule) Handlers() map[common.MessageCode]common.Handler {
+	return h.handlerMap
+}
+
+type fileTransferHandler struct {
+	common.Handler
+	m *fileTransferModule
+}
+
+// OnClose 连接断开后丢弃它参与的传输
+func (h *fileTransferHandler) OnClose(ctx common.Context) {
+	h.m.dropSession(util.GenerateUniqueID(ctx.RemoteAddr()))
+}
+
+func (h *fileTransferModule) fileTransfer(ctx common.Context, transformEntity *msg.FileTransformEntity) error {
+	sender, err := h.uh.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if sender.ID() != transformEntity.From {
+		return common.NewCodeError(common.ErrCodeForbidden, "dont send fake message, your id is "+sender.ID())
+	}
+	receiver, ok := h.route(sender, transformEntity)
+	if !ok && transformEntity.State == msg.FileWaitingSend && len(h.uh.FindUsers(transformEntity.To)) == 0 {
+		// 文件不能离线发送，只通知接收方有人想发送文件
+		text := fmt.Sprintf("wanted to send you file %s (%d bytes), ask them to send it again",
+			transformEntity.FileName, transformEntity.FileSize)
+		queued, err := h.uh.queueOffline(ctx, sender, transformEntity.To, text)
+		if err != nil {
+			return err
+		}
+		if queued {
+			return common.CodeErrorf(common.ErrCodeNotFound, "%s is offline and will be notified of the file", transformEntity.To)
+		}
+	}
+	if !ok {
+		return common.NewCodeError(common.ErrCodeNotFound, "not found receiver")
+	}
+	h.track(transformEntity)
+	h.uh.BroadcastMessage([]*OnlineUser{receiver},
+		&common.Message{
+			Code:    enum.FileTransfer,
+			RawData: transformEntity,
+		})
+	return nil
+}
+
+// route 找到接收方参与传输的连接，没有时使用最近登录的连接。
+// 找到接收方后记录发送方的连接，传输完成或被拒绝时删除双方的记录
+func (h *fileTransferModule) route(sender *OnlineUser, entity *msg.FileTransformEntity) (*OnlineUser, bool) {
+	h.lock.Lock()
+	session, ok := h.sessions[entity.To+"->"+entity.From]
+	h.lock.Unlock()
+	var receiver *OnlineUser
+	if ok {
+		receiver, ok = h.uh.GetOnlineUser(session)
+	}
+	if !ok {
+		for _, user := range h.uh.usersByID(entity.To) {
+			if receiver == nil || user.LoginAt().After(receiver.LoginAt()) {
+				receiver = user
+			}
+		}
+	}
+	if receiver == nil {
+		return nil, false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if entity.State == msg.FileReject || entity.State == msg.FileSendCompleted {
+		delete(h.sessions, entity.From+"->"+entity.To)
+		delete(h.sessions, entity.To+"->"+entity.From)
+	} else {
+		h.sessions[entity.From+"->"+entity.To] = sender.Session()
+	}
+	return receiver, true
+}
+
+// ActiveTransfers 返回进行中的文件传输数量，空闲超时的传输不计入
+func (h *fileTransferModule) ActiveTransfers() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	count := 0
+	for _, lastActive := range h.transfers {
+		if time.Since(lastActive) <= transferIdleTimeout {
+			count++
+		}
+	}
+	return count
+}
+
+// dropSession 删除连接参与的传输和连接记录
+func (h *fileTransferModule) dropSession(session string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for key, value := range h.sessions {
+		if value != session {
+			continue
+		}
+		h.dropTransfer(key)
+	}
+}
+
+// dropTransfer key为任一方向的ID->ID，调用时需持有锁
+func (h *fileTransferModule) dropTransfer(key string) {
+	parts := strings.SplitN(key, "->", 2)
+	if len(parts) != 2 {
+		return
+	}
+	reverse := parts[1] + "->" + parts[0]
+	delete(h.transfers, key)
+	delete(h.transfers, reverse)
+	delete(h.sessions, key)
+	delete(h.sessions, reverse)
+}
+
+// pruneIdle 删除空闲超时的传输，调用时需持有锁
+func (h *fileTransferModule) pruneIdle(now time.Time) {
+	for key, lastActive := range h.transfers {
+		if now.Sub(lastActive) > transferIdleTimeout {
+			h.dropTransfer(key)
+		}
+	}
+}
+
+// track 以发送方->接收方为key记录传输状态，接收方发出的确认和拒绝消息方向相反
+func (h *fileTransferModule) track(entity *msg.FileTransformEntity) {
+	key := entity.From + "->" + entity.To
+	if entity.State == msg.FileAck || entity.State == msg.FileReject {
+		key = entity.To + "->" + entity.From
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.pruneIdle(time.Now())
+	switch entity.State {
+	case msg.FileWaitingSend:
+		h.transfers[key] = time.Now()
+	case msg.FileReject, msg.FileSendCompleted:
+		delete(h.transfers, key)
+	default:
+		if _, ok := h.transfers[key]; ok {
+			h.transfers[key] = time.Now()
+		}
+	}
+}
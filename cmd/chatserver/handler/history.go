@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"gochat/common"
+	"gochat/common/message/enum"
+	"gochat/common/message/msg"
+	"time"
+)
+
+const (
+	// defaultHistoryCount history命令不指定条数时返回的消息数
+	defaultHistoryCount = 20
+	maxHistoryCount     = 200
+)
+
+// recordMessage 保存失败只记录日志，不影响消息的发送
+func (h *userHandler) recordMessage(ctx common.Context, room string, user *OnlineUser, text string) {
+	err := h.config.History.Append(msg.HistoryEntry{
+		Room:         room,
+		From:         user.ID(),
+		FromNickName: user.NikeName(),
+		Text:         text,
+		Time:         time.Now(),
+	})
+	if err != nil {
+		ctx.Logger().Error("save message history error", common.Err(err), common.F("room", room))
+	}
+}
+
+// replayHistory 登录或加入聊天室后发送最近的消息
+func (h *userHandler) replayHistory(ctx common.Context, room string) {
+	if h.config.HistoryReplay <= 0 {
+		return
+	}
+	entries, err := h.config.History.Recent(room, h.config.HistoryReplay)
+	if err != nil {
+		ctx.Logger().Error("load message history error", common.Err(err), common.F("room", room))
+		return
+	}
+	if len(entries) != 0 {
+		_ = ctx.Write(&common.Message{Code: enum.History, RawData: &msg.HistoryMsg{Room: room, Messages: entries}})
+	}
+}
+
+// getHistory 只有聊天室成员可以查看历史消息
+func (h *userHandler) getHistory(ctx common.Context, request *msg.HistoryRequestMsg) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if !h.rooms.isMember(request.Room, user.Session()) {
+		return common.CodeErrorf(common.ErrCodeNotFound, "you are not in room %s", request.Room)
+	}
+	n := request.N
+	if n <= 0 {
+		n = defaultHistoryCount
+	}
+	if n > maxHistoryCount {
+		n = maxHistoryCount
+	}
+	entries, err := h.config.History.Recent(request.Room, n)
+	if err != nil {
+		return common.NewCodeError(common.ErrCodeInternal, "load history error")
+	}
+	return common.Reply(ctx, enum.History, &msg.HistoryMsg{Room: request.Room, Messages: entries})
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"gochat/cmd/chatserver/store"
+	"gochat/common"
+	"gochat/common/message/enum"
+	"gochat/common/message/msg"
+	"gochat/common/util"
+	"time"
+)
+
+// queueOffline 把消息放入离线用户的收件箱，离线用户只能按用户名寻址。
+// to不是注册用户时返回false
+func (h *userHandler) queueOffline(ctx common.Context, sender *OnlineUser, to, text string) (bool, error) {
+	_, ok, err := h.config.Users.Get(to)
+	if err != nil {
+		ctx.Logger().Error("load account error", common.Err(err), common.F("to", to))
+		return false, common.NewCodeError(common.ErrCodeInternal, "save offline message error")
+	}
+	if !ok {
+		return false, nil
+	}
+	queued, err := h.config.Inbox.Push(to, msg.InboxEntry{
+		From:         sender.ID(),
+		FromNickName: sender.NikeName(),
+		Text:         text,
+		Time:         time.Now(),
+	})
+	if errors.Is(err, store.ErrInboxFull) {
+		return true, common.CodeErrorf(common.ErrCodeForbidden, "inbox of %s is full", to)
+	}
+	if err != nil {
+		ctx.Logger().Error("save offline message error", common.Err(err), common.F("to", to))
+		return true, common.NewCodeError(common.ErrCodeInternal, "save offline message error")
+	}
+	return queued, nil
+}
+
+// deliverInbox 注册用户登录后投递离线期间收到的消息，访客没有收件箱
+func (h *userHandler) deliverInbox(ctx common.Context, user *OnlineUser) {
+	if !user.Registered() {
+		return
+	}
+	if err := h.config.Inbox.Register(user.account); err != nil {
+		ctx.Logger().Error("register inbox error", common.Err(err))
+	}
+	entries, err := h.config.Inbox.Take(user.account)
+	if err != nil {
+		ctx.Logger().Error("take offline messages error", common.Err(err))
+	}
+	if len(entries) == 0 {
+		return
+	}
+	if err := ctx.Write(&common.Message{Code: enum.Inbox, RawData: &msg.InboxMsg{Unread: len(entries), Messages: entries}}); err != nil {
+		ctx.Logger().Error("deliver offline messages error", common.Err(err))
+		if err := h.config.Inbox.Restore(user.account, entries); err != nil {
+			ctx.Logger().Error("restore offline messages error", common.Err(err), common.F("lost", len(entries)))
+		}
+	}
+}
+
+// offlineNotice 告诉发送者消息已放入收件箱
+func offlineNotice(to string) *common.Message {
+	return util.NewDisplayMessage(fmt.Sprintf("%s is offline, the message will be delivered on next login", to))
+}
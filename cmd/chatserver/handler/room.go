@@ -0,0 +1,332 @@
+package handler
+
+import (
+	"fmt"
+	"gochat/common"
+	"gochat/common/message/enum"
+	"gochat/common/message/msg"
+	"gochat/common/util"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultRoom 用户登录后自动加入，send命令发送到这里
+	DefaultRoom       = "lobby"
+	maxRoomNameLength = 32
+)
+
+type room struct {
+	name       string
+	inviteOnly bool
+	// members 和 invited 都以连接ID为key
+	members map[string]bool
+	invited map[string]bool
+}
+
+// roomManager 维护聊天室和成员关系，成员下线时退出所有聊天室，
+// 除DefaultRoom外没有成员的聊天室会被删除
+type roomManager struct {
+	lock  sync.Mutex
+	rooms map[string]*room
+}
+
+func newRoomManager() *roomManager {
+	m := &roomManager{rooms: make(map[string]*room)}
+	m.rooms[DefaultRoom] = newRoom(DefaultRoom, false)
+	return m
+}
+
+func newRoom(name string, inviteOnly bool) *room {
+	return &room{name: name, inviteOnly: inviteOnly, members: make(map[string]bool), invited: make(map[string]bool)}
+}
+
+// validRoomName 聊天室名称只能包含字母、数字、'-'和'_'
+func validRoomName(name string) error {
+	if len(name) == 0 || len(name) > maxRoomNameLength {
+		return common.CodeErrorf(common.ErrCodeBadRequest, "room name must be 1-%d characters", maxRoomNameLength)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return common.CodeErrorf(common.ErrCodeBadRequest, "invalid room name %q", name)
+		}
+	}
+	return nil
+}
+
+// create 创建聊天室，创建者自动加入
+func (m *roomManager) create(name string, inviteOnly bool, owner string) error {
+	if err := validRoomName(name); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.rooms[name]; ok {
+		return common.CodeErrorf(common.ErrCodeConflict, "room %s already exists", name)
+	}
+	r := newRoom(name, inviteOnly)
+	r.members[owner] = true
+	m.rooms[name] = r
+	return nil
+}
+
+func (m *roomManager) join(name, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r, ok := m.rooms[name]
+	if !ok {
+		return common.CodeErrorf(common.ErrCodeNotFound, "room %s not found", name)
+	}
+	if r.members[id] {
+		return common.CodeErrorf(common.ErrCodeConflict, "you are already in room %s", name)
+	}
+	if r.inviteOnly && !r.invited[id] {
+		return common.CodeErrorf(common.ErrCodeForbidden, "room %s is invite-only", name)
+	}
+	delete(r.invited, id)
+	r.members[id] = true
+	return nil
+}
+
+func (m *roomManager) leave(name, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r, ok := m.rooms[name]
+	if !ok || !r.members[id] {
+		return common.CodeErrorf(common.ErrCodeNotFound, "you are not in room %s", name)
+	}
+	m.remove(r, id)
+	return nil
+}
+
+// leaveAll 退出所有聊天室并清除邀请，用户登出或断开时调用
+func (m *roomManager) leaveAll(id string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, r := range m.rooms {
+		delete(r.invited, id)
+		if r.members[id] {
+			m.remove(r, id)
+		}
+	}
+}
+
+// remove 调用时需持有锁
+func (m *roomManager) remove(r *room, id string) {
+	delete(r.members, id)
+	if len(r.members) == 0 && r.name != DefaultRoom {
+		delete(m.rooms, r.name)
+	}
+}
+
+// invite 只有成员可以邀请其他用户
+func (m *roomManager) invite(name, inviter string, invitees []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r, ok := m.rooms[name]
+	if !ok || !r.members[inviter] {
+		return common.CodeErrorf(common.ErrCodeNotFound, "you are not in room %s", name)
+	}
+	for _, id := range invitees {
+		if !r.members[id] {
+			r.invited[id] = true
+		}
+	}
+	return nil
+}
+
+func (m *roomManager) isMember(name, id string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r, ok := m.rooms[name]
+	return ok && r.members[id]
+}
+
+// memberIDs 聊天室不存在时返回nil
+func (m *roomManager) memberIDs(name string) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r, ok := m.rooms[name]
+	if !ok {
+		return nil
+	}
+	ids := make([]string, 0, len(r.members))
+	for id := range r.members {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// list 返回对用户可见的聊天室：公开的、已加入的和被邀请的
+func (m *roomManager) list(id string) []msg.RoomInfo {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	rooms := make([]msg.RoomInfo, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		if r.inviteOnly && !r.members[id] && !r.invited[id] {
+			continue
+		}
+		rooms = append(rooms, msg.RoomInfo{
+			Name:       r.name,
+			InviteOnly: r.inviteOnly,
+			Members:    len(r.members),
+			Joined:     r.members[id],
+		})
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms
+}
+
+func roomCommandSpecs() []common.CommandSpec {
+	room := common.ArgSpec{Name: "room"}
+	return []common.CommandSpec{
+		{
+			Name:  "create",
+			Code:  enum.RoomCreate,
+			Args:  []common.ArgSpec{{Name: "name"}},
+			Flags: []common.FlagSpec{{Name: "invite", Type: "bool", Help: "only invited users can join"}},
+			Help:  "create a room and join it",
+		},
+		{Name: "join", Code: enum.RoomJoin, Args: []common.ArgSpec{room}, Payload: "room", Help: "join a room"},
+		{Name: "leave", Code: enum.RoomLeave, Args: []common.ArgSpec{room}, Payload: "room", Help: "leave a room"},
+		{
+			Name: "invite",
+			Code: enum.RoomInvite,
+			Args: []common.ArgSpec{room, {Name: "user", Help: "user ID or nickname", Complete: "user"}},
+			Help: "invite a user to a room",
+		},
+		{Name: "rooms", Code: enum.GetRoomList, Help: "show rooms you can join"},
+		{Name: "members", Code: enum.GetRoomMembers, Args: []common.ArgSpec{room}, Payload: "room", Help: "show members of a room"},
+		{
+			Name: "say",
+			Code: enum.RoomMessage,
+			Args: []common.ArgSpec{room, {Name: "text", Variadic: true}},
+			Help: "send message to a room",
+		},
+	}
+}
+
+// BroadcastRoom 发送给聊天室的全部在线成员
+func (h *userHandler) BroadcastRoom(name string, message *common.Message) {
+	for _, id := range h.rooms.memberIDs(name) {
+		user, ok := h.GetOnlineUser(id)
+		if !ok {
+			continue
+		}
+		if err := user.ctx.Write(message); err != nil {
+			h.RemoveOnlineUser(id)
+			_ = user.ctx.Close()
+		}
+	}
+}
+
+func (h *userHandler) createRoom(ctx common.Context, message *msg.RoomCreateMsg) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := h.rooms.create(message.Name, message.InviteOnly, user.Session()); err != nil {
+		return err
+	}
+	return ctx.Write(util.NewDisplayMessage("room " + message.Name + " created"))
+}
+
+func (h *userHandler) joinRoom(ctx common.Context, name string) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := h.rooms.join(name, user.Session()); err != nil {
+		return err
+	}
+	h.replayHistory(ctx, name)
+	go h.BroadcastRoom(name, util.NewDisplayMessage(fmt.Sprintf("[#%s] %s加入了", name, user.NikeName())))
+	return nil
+}
+
+func (h *userHandler) leaveRoom(ctx common.Context, name string) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := h.rooms.leave(name, user.Session()); err != nil {
+		return err
+	}
+	_ = ctx.Write(util.NewDisplayMessage("you left room " + name))
+	go h.BroadcastRoom(name, util.NewDisplayMessage(fmt.Sprintf("[#%s] %s离开了", name, user.NikeName())))
+	return nil
+}
+
+// inviteRoom 按用户ID或昵称邀请时该用户的所有连接都会被邀请
+func (h *userHandler) inviteRoom(ctx common.Context, message *msg.RoomInviteMsg) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	invitees := h.FindUsers(message.User)
+	if len(invitees) == 0 {
+		return common.CodeErrorf(common.ErrCodeNotFound, "user %s is offline", message.User)
+	}
+	ids := make([]string, 0, len(invitees))
+	for _, invitee := range invitees {
+		ids = append(ids, invitee.Session())
+	}
+	if err := h.rooms.invite(message.Room, user.Session(), ids); err != nil {
+		return err
+	}
+	go h.BroadcastMessage(invitees, util.NewDisplayMessage(
+		fmt.Sprintf("%s invited you to room %s, use \"join %s\" to enter", user.NikeName(), message.Room, message.Room)))
+	return ctx.Write(util.NewDisplayMessage("invited " + message.User + " to room " + message.Room))
+}
+
+func (h *userHandler) getRoomList(ctx common.Context, _ *struct{}) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	return common.Reply(ctx, enum.RoomList, &msg.RoomListMsg{Rooms: h.rooms.list(user.Session())})
+}
+
+// getRoomMembers 只有成员可以查看成员列表
+func (h *userHandler) getRoomMembers(ctx common.Context, name string) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if !h.rooms.isMember(name, user.Session()) {
+		return common.CodeErrorf(common.ErrCodeNotFound, "you are not in room %s", name)
+	}
+	members := &msg.RoomMembersMsg{Room: name, Members: make([]msg.OnlineUserInfo, 0)}
+	for _, id := range h.rooms.memberIDs(name) {
+		if member, ok := h.GetOnlineUser(id); ok {
+			members.Members = append(members.Members, member.Info())
+		}
+	}
+	sort.Slice(members.Members, func(i, j int) bool {
+		return members.Members[i].NickName < members.Members[j].NickName
+	})
+	return common.Reply(ctx, enum.RoomMembers, members)
+}
+
+func (h *userHandler) roomMessage(ctx common.Context, message *msg.RoomMsg) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if !h.rooms.isMember(message.Room, user.Session()) {
+		return common.CodeErrorf(common.ErrCodeNotFound, "you are not in room %s", message.Room)
+	}
+	h.recordMessage(ctx, message.Room, user, message.Text)
+	go h.BroadcastRoom(message.Room, &common.Message{
+		Code: enum.RoomMessage,
+		RawData: &msg.RoomMsg{
+			Room:         message.Room,
+			Text:         message.Text,
+			From:         user.ID(),
+			FromNickName: user.NikeName(),
+		},
+	})
+	return nil
+}
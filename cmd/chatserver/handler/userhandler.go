@@ -1,22 +1,40 @@
 package handler
 
 import (
-	"encoding/json"
 	"fmt"
+	"gochat/cmd/chatserver/store"
 	"gochat/common"
 	"gochat/common/message/enum"
 	"gochat/common/message/msg"
 	"gochat/common/util"
-	"log"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 )
 
 type OnlineUser struct {
-	ctx  common.Context
-	user *msg.User
-	addr string
+	ctx     common.Context
+	user    *msg.User
+	addr    string
+	loginAt time.Time
+	// id 注册用户为账号ID，访客为连接ID
+	id string
+	// account 注册用户的用户名，访客为空
+	account string
+}
+
+// ID 注册用户的ID在重连后不变，同一账号的多个连接ID相同
+func (o *OnlineUser) ID() string {
+	return o.id
+}
+
+// Session 连接ID，在线用户和聊天室成员以此区分
+func (o *OnlineUser) Session() string {
+	return util.GenerateUniqueID(o.addr)
+}
+
+func (o *OnlineUser) Registered() bool {
+	return len(o.account) != 0
 }
 
 func (o *OnlineUser) Addr() string {
@@ -27,65 +45,163 @@ func (o *OnlineUser) NikeName() string {
 	return o.user.NickName
 }
 
-const UserHandlerCode common.MessageCode = -100
+func (o *OnlineUser) Info() msg.OnlineUserInfo {
+	return msg.OnlineUserInfo{ID: o.ID(), NickName: o.NikeName()}
+}
+
+func (o *OnlineUser) LoginAt() time.Time {
+	return o.loginAt
+}
+
+const ChatModuleName = "chat"
+
+// ChatConfig 聊天模块的配置
+type ChatConfig struct {
+	// History 为nil时消息只保存在内存中
+	History store.HistoryStore
+	// HistoryReplay 登录或加入聊天室时发送的历史消息数，0表示不发送
+	HistoryReplay int
+	// Inbox 为nil时离线消息只保存在内存中，不限制数量
+	Inbox store.InboxStore
+	// Users 为nil时注册用户只保存在内存中
+	Users store.UserStore
+	// RequireAccount 为true时不允许访客登录
+	RequireAccount bool
+}
 
-// UserHandler 把用户行为聚合到一个Handler里管理
+// userHandler 把用户行为聚合到一个模块里管理
 type userHandler struct {
+	common.BaseModule
 	onlineUserMap *sync.Map
 	handlerMap    map[common.MessageCode]common.Handler
+	catalog       common.CommandCatalog
+	rooms         *roomManager
+	config        ChatConfig
 }
 
-func NewUserHandler() *userHandler {
+func NewUserHandler(config ChatConfig) *userHandler {
+	if config.History == nil {
+		config.History = store.NewMemoryHistory()
+	}
+	if config.Inbox == nil {
+		config.Inbox = store.NewMemoryInbox(store.InboxLimits{})
+	}
+	if config.Users == nil {
+		config.Users = store.NewMemoryUsers()
+	}
 	uh := &userHandler{
 		onlineUserMap: &sync.Map{},
+		rooms:         newRoomManager(),
+		config:        config,
 	}
 	uh.handlerMap = map[common.MessageCode]common.Handler{
-		enum.UserLogin:         &loginHandler{uh: uh},
-		enum.GetOnlineUserList: &getOnlineUserListHandler{uh: uh},
-		enum.UserLogout:        &logoutHandler{uh: uh},
-		enum.SendMessage:       &sendMessageHandler{uh: uh},
-		enum.FileTransfer:      &fileTransferHandler{uh: uh},
+		enum.UserLogin:         &loginHandler{Handler: common.NewTypedHandler(uh.login), uh: uh},
+		enum.GetOnlineUserList: common.NewTypedHandler(uh.getOnlineUserList),
+		enum.UserLogout:        common.NewTypedHandler(uh.logout),
+		enum.SendMessage:       common.NewTypedHandler(uh.sendMessage),
+		enum.PrivateMessage:    common.NewTypedHandler(uh.privateMessage),
+		enum.RoomCreate:        common.NewTypedHandler(uh.createRoom),
+		enum.RoomJoin:          common.NewTypedHandler(uh.joinRoom),
+		enum.RoomLeave:         common.NewTypedHandler(uh.leaveRoom),
+		enum.RoomInvite:        common.NewTypedHandler(uh.inviteRoom),
+		enum.GetRoomList:       common.NewTypedHandler(uh.getRoomList),
+		enum.GetRoomMembers:    common.NewTypedHandler(uh.getRoomMembers),
+		enum.RoomMessage:       common.NewTypedHandler(uh.roomMessage),
+		enum.GetHistory:        common.NewTypedHandler(uh.getHistory),
+		enum.UserRegister:      common.NewTypedHandler(uh.register),
+		enum.ChangePassword:    common.NewTypedHandler(uh.changePassword),
+		enum.UserKick:          common.NewTypedHandler(uh.kickUser),
 	}
 	return uh
 }
 
-func (h *userHandler) OnMessage(_ common.Context, _ *common.RawMessage) error {
-	return nil
+func (h *userHandler) Name() string {
+	return ChatModuleName
 }
 
-func (h *userHandler) OnActive(ctx common.Context) {
-	_ = ctx.Write(util.NewDisplayMessage("hello, please login"))
+func (h *userHandler) Handlers() map[common.MessageCode]common.Handler {
+	return h.handlerMap
 }
 
-func (h *userHandler) OnClose(_ common.Context) {}
+func (h *userHandler) Init(env common.Env) error {
+	if catalog, ok := env.(common.CommandCatalog); ok {
+		h.catalog = catalog
+	}
+	return nil
+}
 
-func (h *userHandler) OnInit(env common.Env) {
-	for code, handler := range h.handlerMap {
-		env.AddHandler(code, handler)
+// CommandSpecs 登录后客户端可以使用的命令
+func (h *userHandler) CommandSpecs() []common.CommandSpec {
+	specs := []common.CommandSpec{
+		{
+			Name:    "send",
+			Code:    enum.SendMessage,
+			Args:    []common.ArgSpec{{Name: "message", Variadic: true, Help: "quote to keep repeated spaces"}},
+			Payload: "message",
+			Help:    "send message to the lobby",
+		},
+		{
+			Name: "msg",
+			Code: enum.PrivateMessage,
+			Args: []common.ArgSpec{
+				{Name: "to", Help: "user ID or nickname", Complete: "user"},
+				{Name: "text", Variadic: true},
+			},
+			Help: "send private message to one user",
+		},
+		{
+			Name:  "history",
+			Code:  enum.GetHistory,
+			Args:  []common.ArgSpec{{Name: "n", Type: "int", Optional: true, Help: "number of messages"}},
+			Flags: []common.FlagSpec{{Name: "room", Default: DefaultRoom}},
+			Help:  "show recent messages of a room",
+		},
+		{Name: "userlist", Code: enum.GetOnlineUserList, Help: "show online users"},
+		{Name: "logout", Code: enum.UserLogout, Help: "logout but keep the connection"},
+		{
+			Name: "passwd",
+			Code: enum.ChangePassword,
+			Args: []common.ArgSpec{{Name: "old", Secret: true}, {Name: "new", Secret: true}},
+			Help: "change your password",
+		},
+		{
+			Name: "kick",
+			Code: enum.UserKick,
+			Args: []common.ArgSpec{
+				{Name: "user", Help: "user ID or nickname", Complete: "user"},
+				{Name: "reason", Optional: true, Variadic: true},
+			},
+			Help: "disconnect all sessions of a user",
+		},
 	}
+	return append(specs, roomCommandSpecs()...)
 }
 
-func (h *userHandler) OnRemove(env common.Env) {
-	for _, handler := range h.handlerMap {
-		handler.OnRemove(env)
+// sendCatalog 登录成功后把服务端支持的命令发给客户端
+func (h *userHandler) sendCatalog(ctx common.Context) error {
+	if h.catalog == nil {
+		return nil
 	}
+	return ctx.Write(&common.Message{Code: enum.CommandCatalog, RawData: h.catalog.Commands()})
 }
 
 func (h *userHandler) AddOnlineUser(user *OnlineUser) {
-	h.onlineUserMap.Store(util.GenerateUniqueID(user.Addr()), user)
+	h.onlineUserMap.Store(user.Session(), user)
 }
 
-func (h *userHandler) RemoveOnlineUser(id string) {
-	_, ok := h.GetOnlineUser(id)
+// RemoveOnlineUser 按连接ID移除，同时退出所有聊天室
+func (h *userHandler) RemoveOnlineUser(session string) {
+	_, ok := h.GetOnlineUser(session)
 	if ok {
-		h.onlineUserMap.Delete(id)
+		h.onlineUserMap.Delete(session)
+		h.rooms.leaveAll(session)
 	}
 }
 
 func (h *userHandler) BroadcastMessage(targetUser []*OnlineUser, message *common.Message) {
 	if len(targetUser) != 0 {
 		for _, user := range targetUser {
-			onlineUser, ok := h.GetOnlineUser(util.GenerateUniqueID(user.Addr()))
+			onlineUser, ok := h.GetOnlineUser(user.Session())
 			if ok {
 				_ = onlineUser.ctx.Write(message)
 			}
@@ -96,20 +212,76 @@ func (h *userHandler) BroadcastMessage(targetUser []*OnlineUser, message *common
 	for i := range users {
 		err := users[i].ctx.Write(message)
 		if err != nil {
-			h.RemoveOnlineUser(util.GenerateUniqueID(users[i].Addr()))
+			h.RemoveOnlineUser(users[i].Session())
 			_ = users[i].ctx.Close()
 		}
 	}
 }
 
-func (h *userHandler) GetOnlineUser(id string) (*OnlineUser, bool) {
-	user, ok := h.onlineUserMap.Load(id)
+// GetOnlineUser 按连接ID查找
+func (h *userHandler) GetOnlineUser(session string) (*OnlineUser, bool) {
+	user, ok := h.onlineUserMap.Load(session)
 	if !ok {
 		return nil, ok
 	}
 	return user.(*OnlineUser), ok
 }
 
+// FindUsers 按连接ID、用户ID或昵称查找在线用户，用户ID和昵称可能对应多个连接
+func (h *userHandler) FindUsers(idOrNickName string) []*OnlineUser {
+	if users := h.usersByID(idOrNickName); len(users) != 0 {
+		return users
+	}
+	return h.usersByNickName(idOrNickName)
+}
+
+// findUser 同FindUsers，但结果必须属于同一个用户，昵称被多个用户使用时返回冲突错误
+func (h *userHandler) findUser(idOrNickName string) ([]*OnlineUser, error) {
+	users := h.FindUsers(idOrNickName)
+	for _, user := range users {
+		if user.ID() != users[0].ID() {
+			return nil, common.CodeErrorf(common.ErrCodeConflict,
+				"nickname %s is used by more than one user, please use the user ID", idOrNickName)
+		}
+	}
+	return users, nil
+}
+
+// usersByID 按连接ID或用户ID查找
+func (h *userHandler) usersByID(id string) []*OnlineUser {
+	if user, ok := h.GetOnlineUser(id); ok {
+		return []*OnlineUser{user}
+	}
+	users := make([]*OnlineUser, 0)
+	h.onlineUserMap.Range(func(_, value interface{}) bool {
+		if user := value.(*OnlineUser); user.ID() == id {
+			users = append(users, user)
+		}
+		return true
+	})
+	return users
+}
+
+func (h *userHandler) usersByNickName(nickName string) []*OnlineUser {
+	users := make([]*OnlineUser, 0)
+	h.onlineUserMap.Range(func(_, value interface{}) bool {
+		if user := value.(*OnlineUser); user.NikeName() == nickName {
+			users = append(users, user)
+		}
+		return true
+	})
+	return users
+}
+
+func (h *userHandler) OnlineUserCount() int {
+	count := 0
+	h.onlineUserMap.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
 func (h *userHandler) GetOnlineUsers(limit int) []*OnlineUser {
 	users := make([]*OnlineUser, 0)
 	h.onlineUserMap.Range(func(key, value interface{}) bool {
@@ -123,153 +295,219 @@ func (h *userHandler) GetOnlineUsers(limit int) []*OnlineUser {
 	return users
 }
 
-func (h *userHandler) CheckLogin(ctx common.Context) (*OnlineUser, bool) {
-	user, ok := h.GetOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
-	if !ok {
-		err := ctx.Write(util.NewDisplayMessage("please login"))
-		if err != nil {
-			log.Println(err)
-			_ = ctx.Close()
-		}
-		return nil, false
+// Kick 通知用户后断开其连接，id为用户ID时断开该用户的所有连接
+func (h *userHandler) Kick(id, reason string) error {
+	users := h.usersByID(id)
+	if len(users) == 0 {
+		return common.CodeErrorf(common.ErrCodeNotFound, "user %s not found", id)
 	}
-	return user, true
-}
-
-type loginHandler struct {
-	common.BaseHandler
-	uh *userHandler
+	text := "you have been kicked"
+	if len(reason) != 0 {
+		text += ": " + reason
+	}
+	for _, user := range users {
+		h.RemoveOnlineUser(user.Session())
+		_ = user.ctx.Write(util.NewDisplayMessage(text))
+		_ = user.ctx.Close()
+		go h.broadcastPresence(user, false, user.NikeName()+"被踢出了")
+	}
+	return nil
 }
 
-func (h *loginHandler) OnMessage(ctx common.Context, rawMessage *common.RawMessage) error {
-	message := &msg.LoginMsg{}
-	if err := json.Unmarshal(rawMessage.RawData, message); err != nil {
-		h.uh.RemoveOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
-		_ = ctx.Write(util.NewDisplayMessage("invalid data"))
-		_ = ctx.Close()
+// kickUser 是否有权限由服务端的Authorizer检查，默认只有moderator和admin可以使用
+func (h *userHandler) kickUser(ctx common.Context, message *msg.KickMsg) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
 		return err
 	}
-	if user, ok := h.uh.GetOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr())); ok {
-		err := ctx.Write(util.NewDisplayMessage("your already logged"))
-		if err != nil {
-			h.uh.RemoveOnlineUser(util.GenerateUniqueID(user.Addr()))
-			_ = ctx.Close()
-		}
-		return err
+	targets := h.FindUsers(message.User)
+	if len(targets) == 0 {
+		return common.CodeErrorf(common.ErrCodeNotFound, "user %s is offline", message.User)
 	}
-	user := &OnlineUser{
-		ctx: ctx,
-		user: &msg.User{
-			NickName: message.NickName,
-		},
-		addr: ctx.RemoteAddr(),
+	ids := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if target.ID() == user.ID() {
+			return common.NewCodeError(common.ErrCodeBadRequest, "you can not kick yourself")
+		}
+		ids[target.ID()] = true
 	}
-	h.uh.AddOnlineUser(user)
-	loginMsg := fmt.Sprintf("login success, now %s, your IP is %s, ID=%s", time.Now().String(), user.Addr(), util.GenerateUniqueID(user.Addr()))
-	if err := ctx.Write(util.NewDisplayMessage(loginMsg)); err != nil {
-		h.uh.RemoveOnlineUser(util.GenerateUniqueID(user.Addr()))
-		_ = ctx.Close()
-		return err
+	for id := range ids {
+		if err := h.Kick(id, message.Reason); err != nil {
+			return err
+		}
+		ctx.Logger().Info("user kicked", common.F("target", id), common.F("reason", message.Reason))
 	}
-	go h.uh.BroadcastMessage(nil, util.NewDisplayMessage(user.NikeName()+"上线了"))
-	return nil
+	return ctx.Write(util.NewDisplayMessage("kicked " + message.User))
 }
 
-func (h *loginHandler) OnActive(_ common.Context) {}
+// Announce 向所有在线用户广播系统公告
+func (h *userHandler) Announce(text string) {
+	h.BroadcastMessage(nil, util.NewDisplayMessage("[系统公告] "+text))
+}
 
-func (h *loginHandler) OnClose(ctx common.Context) {
-	user, ok := h.uh.GetOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
-	if !ok {
+// broadcastPresence 广播提示文字和结构化的上下线事件，用户还有其他连接在线时不发送下线事件
+func (h *userHandler) broadcastPresence(user *OnlineUser, online bool, text string) {
+	h.BroadcastMessage(nil, util.NewDisplayMessage(text))
+	if !online && len(h.usersByID(user.ID())) != 0 {
 		return
 	}
-	h.uh.RemoveOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
-	h.uh.BroadcastMessage(nil, util.NewDisplayMessage(user.NikeName()+"掉线了"))
-}
-
-type logoutHandler struct {
-	common.BaseHandler
-	uh *userHandler
+	h.BroadcastMessage(nil, &common.Message{
+		Code:    enum.UserPresence,
+		RawData: &msg.UserPresenceMsg{OnlineUserInfo: user.Info(), Online: online},
+	})
 }
 
-func (h *logoutHandler) OnMessage(ctx common.Context, _ *common.RawMessage) error {
-	user, ok := h.uh.CheckLogin(ctx)
+func (h *userHandler) CheckLogin(ctx common.Context) (*OnlineUser, error) {
+	user, ok := h.GetOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
 	if !ok {
-		return nil
+		return nil, common.NewCodeError(common.ErrCodeUnauthorized, "please login")
 	}
-	h.uh.RemoveOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
-	_ = ctx.Write(util.NewDisplayMessage("logout success"))
-	go h.uh.BroadcastMessage(nil, util.NewDisplayMessage(user.NikeName()+"离开了"))
-	return nil
+	return user, nil
 }
 
-type getOnlineUserListHandler struct {
-	common.BaseHandler
+type loginHandler struct {
+	common.Handler
 	uh *userHandler
 }
 
-func (h *getOnlineUserListHandler) OnMessage(ctx common.Context, _ *common.RawMessage) error {
-	_, ok := h.uh.CheckLogin(ctx)
+func (h *loginHandler) OnActive(ctx common.Context) {
+	_ = ctx.Write(util.NewDisplayMessage("hello, please login"))
+}
+
+func (h *loginHandler) OnClose(ctx common.Context) {
+	user, ok := h.uh.GetOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
 	if !ok {
-		return nil
+		return
 	}
-	users := h.uh.GetOnlineUsers(1000)
-	builder := &strings.Builder{}
-	builder.WriteString(fmt.Sprintf("online user number: %d\n", len(users)))
-	for i := range users {
-		builder.WriteString(fmt.Sprintf("ID=%s, nickname=%s\n", util.GenerateUniqueID(users[i].Addr()), users[i].NikeName()))
+	h.uh.RemoveOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
+	h.uh.broadcastPresence(user, false, user.NikeName()+"掉线了")
+}
+
+func (h *userHandler) login(ctx common.Context, message *msg.LoginMsg) error {
+	if _, ok := h.GetOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr())); ok {
+		return common.NewCodeError(common.ErrCodeConflict, "you are already logged in")
 	}
-	err := ctx.Write(util.NewDisplayMessage(builder.String()))
-	if err != nil {
+	// 服务端配置了Authenticator时连接在分发前已经认证，否则在这里用昵称和密码认证
+	principal := common.PrincipalOf(ctx)
+	if principal == nil {
+		var err error
+		if principal, err = h.authenticate(ctx, message.NickName, message.Password); err != nil {
+			return err
+		}
+	}
+	user := &OnlineUser{
+		ctx:     common.ConnectionOf(ctx),
+		user:    &msg.User{},
+		addr:    ctx.RemoteAddr(),
+		loginAt: time.Now(),
+	}
+	if err := h.bindPrincipal(user, principal); err != nil {
+		return err
+	}
+	h.AddOnlineUser(user)
+	_ = h.rooms.join(DefaultRoom, user.Session())
+	ctx.AddLogFields(common.F("user", user.ID()))
+	loginMsg := fmt.Sprintf("login success, now %s, your IP is %s, ID=%s", time.Now().String(), user.Addr(), user.ID())
+	if err := ctx.Write(util.NewDisplayMessage(loginMsg)); err != nil {
+		h.RemoveOnlineUser(user.Session())
 		_ = ctx.Close()
 		return err
 	}
+	if err := h.sendCatalog(ctx); err != nil {
+		ctx.Logger().Error("send command catalog error", common.Err(err))
+	}
+	h.replayHistory(ctx, DefaultRoom)
+	h.deliverInbox(ctx, user)
+	go h.broadcastPresence(user, true, user.NikeName()+"上线了")
 	return nil
 }
 
-type sendMessageHandler struct {
-	common.BaseHandler
-	uh *userHandler
+func (h *userHandler) logout(ctx common.Context, _ *struct{}) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	h.RemoveOnlineUser(util.GenerateUniqueID(ctx.RemoteAddr()))
+	// 令牌和证书认证属于连接本身，登出后仍然有效
+	if c, ok := ctx.(common.PrincipalContext); ok {
+		if principal := c.Principal(); principal != nil && (principal.Method == methodPassword || principal.Method == methodGuest) {
+			c.SetPrincipal(nil)
+		}
+	}
+	_ = ctx.Write(util.NewDisplayMessage("logout success"))
+	go h.broadcastPresence(user, false, user.NikeName()+"离开了")
+	return nil
 }
 
-func (h *sendMessageHandler) OnMessage(ctx common.Context, msg *common.RawMessage) error {
-	user, ok := h.uh.CheckLogin(ctx)
-	if !ok {
-		return nil
+func (h *userHandler) getOnlineUserList(ctx common.Context, _ *struct{}) error {
+	if _, err := h.CheckLogin(ctx); err != nil {
+		return err
 	}
-	str := ""
-	if err := json.Unmarshal(msg.RawData, &str); err != nil {
+	users := h.GetOnlineUsers(1000)
+	list := &msg.OnlineUserListMsg{Users: make([]msg.OnlineUserInfo, 0, len(users))}
+	for _, user := range users {
+		list.Users = append(list.Users, user.Info())
+	}
+	sort.Slice(list.Users, func(i, j int) bool {
+		return list.Users[i].NickName < list.Users[j].NickName
+	})
+	if err := common.Reply(ctx, enum.OnlineUserList, list); err != nil {
+		_ = ctx.Close()
 		return err
 	}
-	go h.uh.BroadcastMessage(nil,
-		util.NewDisplayMessage(user.NikeName()+",ID:"+util.GenerateUniqueID(user.Addr())+"\n\t"+str))
 	return nil
 }
 
-type fileTransferHandler struct {
-	common.BaseHandler
-	uh *userHandler
+func (h *userHandler) sendMessage(ctx common.Context, str string) error {
+	user, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if !h.rooms.isMember(DefaultRoom, user.Session()) {
+		return common.CodeErrorf(common.ErrCodeForbidden, "join %s to send messages", DefaultRoom)
+	}
+	h.recordMessage(ctx, DefaultRoom, user, str)
+	go h.BroadcastRoom(DefaultRoom,
+		util.NewDisplayMessage(user.NikeName()+",ID:"+user.ID()+"\n\t"+str))
+	return nil
 }
 
-func (h *fileTransferHandler) OnMessage(ctx common.Context, rawMessage *common.RawMessage) error {
-	_, ok := h.uh.CheckLogin(ctx)
-	if !ok {
-		return nil
+// privateMessage 把消息发给目标用户，并回显给发送者的其他连接，
+// 目标用户离线时放入其收件箱
+func (h *userHandler) privateMessage(ctx common.Context, message *msg.PrivateMsg) error {
+	sender, err := h.CheckLogin(ctx)
+	if err != nil {
+		return err
 	}
-	transformEntity := &msg.FileTransformEntity{}
-	if err := json.Unmarshal(rawMessage.RawData, &transformEntity); err != nil {
+	receivers, err := h.findUser(message.To)
+	if err != nil {
 		return err
 	}
-	if util.GenerateUniqueID(ctx.RemoteAddr()) != transformEntity.From {
-		return ctx.Write(util.NewDisplayMessage("dont send fake message, your id is " + util.GenerateUniqueID(ctx.RemoteAddr())))
+	if len(receivers) == 0 {
+		queued, err := h.queueOffline(ctx, sender, message.To, message.Text)
+		if err != nil {
+			return err
+		}
+		if !queued {
+			return common.CodeErrorf(common.ErrCodeNotFound, "user %s is offline", message.To)
+		}
+		return ctx.Write(offlineNotice(message.To))
 	}
-	receiver, ok := h.uh.GetOnlineUser(transformEntity.To)
-	if !ok {
-		return ctx.Write(util.NewDisplayMessage("not found receiver"))
+	private := &msg.PrivateMsg{
+		To:           receivers[0].ID(),
+		ToNickName:   receivers[0].NikeName(),
+		Text:         message.Text,
+		From:         sender.ID(),
+		FromNickName: sender.NikeName(),
 	}
-	h.uh.BroadcastMessage([]*OnlineUser{receiver},
-		&common.Message{
-			Code:    enum.FileTransfer,
-			RawData: transformEntity,
-		})
-	return nil
+	delivered := map[string]bool{sender.Session(): true}
+	targets := make([]*OnlineUser, 0, len(receivers))
+	for _, user := range append(receivers, h.FindUsers(sender.ID())...) {
+		if !delivered[user.Session()] {
+			delivered[user.Session()] = true
+			targets = append(targets, user)
+		}
+	}
+	go h.BroadcastMessage(targets, &common.Message{Code: enum.PrivateMessage, RawData: private})
+	return common.Reply(ctx, enum.PrivateMessage, private)
 }
@@ -2,7 +2,6 @@ package interceptor
 
 import (
 	"gochat/common"
-	"log"
 	"sync/atomic"
 )
 
@@ -15,13 +14,15 @@ func NewCountInterceptor() *countInterceptor {
 	return &countInterceptor{}
 }
 
-func (i *countInterceptor) OnReadAfter(common.Context, *common.RawMessage) error {
-	log.Printf("receive message count=%d", atomic.AddInt64(&i.receiveMsgNum, 1))
+func (i *countInterceptor) OnReadAfter(ctx common.Context, msg *common.RawMessage) error {
+	ctx.Logger().Debug("receive message", common.F("code", msg.Code),
+		common.F("count", atomic.AddInt64(&i.receiveMsgNum, 1)))
 	return nil
 }
 
-func (i *countInterceptor) OnWriteBefore(common.Context, *common.Message) {
-	log.Printf("send message count=%d", atomic.AddInt64(&i.sendMsgNum, 1))
+func (i *countInterceptor) OnWriteBefore(ctx common.Context, msg *common.Message) {
+	ctx.Logger().Debug("send message", common.F("code", msg.Code),
+		common.F("count", atomic.AddInt64(&i.sendMsgNum, 1)))
 }
 
 func (i *countInterceptor) Name() string {
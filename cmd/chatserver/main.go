@@ -1,33 +1,219 @@
 package main
 
 import (
-	"fmt"
+	"errors"
+	"flag"
+	"gochat/cmd/chatserver/admin"
+	"gochat/cmd/chatserver/authz"
+	"gochat/cmd/chatserver/config"
 	"gochat/cmd/chatserver/handler"
 	"gochat/cmd/chatserver/interceptor"
+	"gochat/cmd/chatserver/store"
 	"gochat/common"
 	"gochat/common/message/enum"
 	"gochat/common/util"
 	"gochat/goserver"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
 func main() {
-	log.Println("输入要监听的IP端口，不输入默认为localhost:8080")
-	address := util.ScanAddress("localhost:8080")
-	s, err := goserver.NewServer(address)
+	options, err := config.Load(os.Args[0], os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
-		time.Sleep(time.Second * 5)
+		log.Fatal(err)
+	}
+	cfg := options.Config
+	if options.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
+	logger, err := common.ParseLogger(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(options.ConfigFile) != 0 {
+		logger.Info("config loaded", common.F("file", options.ConfigFile))
+	}
+	if len(cfg.Storage.DataDir) != 0 {
+		if err := os.MkdirAll(cfg.Storage.DataDir, 0o755); err != nil {
+			logger.Fatal("create data dir error", common.Err(err))
+		}
+	}
+	tlsConfig, err := cfg.TLS.Load()
+	if err != nil {
+		logger.Fatal("load tls config error", common.Err(err))
+	}
+	history, err := openHistory(cfg.Storage)
+	if err != nil {
+		logger.Fatal("open message history error", common.Err(err))
+	}
+	inbox, err := openInbox(cfg.Storage)
+	if err != nil {
+		logger.Fatal("open inbox error", common.Err(err))
+	}
+	accounts, err := openUsers(cfg.Storage)
+	if err != nil {
+		logger.Fatal("open user store error", common.Err(err))
+	}
+	users := handler.NewUserHandler(handler.ChatConfig{
+		History:        history,
+		HistoryReplay:  cfg.Storage.HistoryReplay,
+		Inbox:          inbox,
+		Users:          accounts,
+		RequireAccount: cfg.Auth.RequireAccount,
+	})
+	fileTransfer := handler.NewFileTransferModule(users)
+	policy, err := authz.NewPolicy(cfg.Auth.Roles, cfg.Auth.Policy, commandSpecs(users, fileTransfer))
+	if err != nil {
+		logger.Fatal("invalid auth.policy", common.Err(err))
+	}
+	s, err := goserver.NewServerWithConfig(goserver.Config{
+		Address:          cfg.Address,
+		Logger:           logger,
+		HandshakeTimeout: time.Duration(cfg.Limits.HandshakeTimeout),
+		MaxConnections:   cfg.Limits.MaxConnections,
+		MetricsAddress:   cfg.MetricsAddress,
+		TLSConfig:        tlsConfig,
+		DebugAddress:     cfg.DebugAddress,
+		ShutdownDelay:    time.Duration(cfg.Limits.ShutdownDelay),
+		Authenticator:    policy.Authenticator(newAuthenticator(cfg, users.AuthenticateLogin)),
+		Authorizer:       policy,
+	})
+	if err != nil {
+		logger.Fatal("start server error", common.Err(err))
+	}
+	s.SetDefaultHandlerTimeout(time.Duration(cfg.Limits.HandlerTimeout))
 	s.AddInterceptor(interceptor.NewCountInterceptor())
 	s.AddHandler(enum.Display, common.NewDisplayHandler(
 		func(msg string) error {
-			log.Println(msg)
+			logger.Info(msg)
 			return nil
 		}))
-	s.AddHandler(enum.Pong, common.NewPongHandler(enum.Ping, time.Second*15, time.Minute))
-	s.AddHandler(handler.UserHandlerCode, handler.NewUserHandler())
+	util.AssertNotError(s.AddModule(common.NewHeartbeat(common.HeartbeatConfig{
+		PingCode: enum.Ping,
+		PongCode: enum.Pong,
+		Interval: time.Duration(cfg.Heartbeat.Interval),
+		Timeout:  time.Duration(cfg.Heartbeat.Timeout),
+	})))
+	s.AddHandler(enum.DescribeProtocol, common.NewDescribeProtocolHandler(enum.DescribeProtocol))
+	util.AssertNotError(s.AddModule(users))
+	util.AssertNotError(s.AddModule(fileTransfer))
+	if err := cfg.ValidateModules(s.Modules()); err != nil {
+		logger.Fatal("invalid modules", common.Err(err))
+	}
+	for name, enabled := range cfg.Modules {
+		s.SetModuleEnabled(name, enabled)
+	}
+	s.Metrics().NewGaugeFunc("gochat_online_users", "Number of logged in users.", func() float64 {
+		return float64(users.OnlineUserCount())
+	})
+	s.Metrics().NewGaugeFunc("gochat_file_transfers_active", "Number of file transfers in progress.", func() float64 {
+		return float64(fileTransfer.ActiveTransfers())
+	})
+	if cfg.Admin.Address != "" {
+		api, err := admin.NewAPI(admin.Config{
+			Token:  cfg.Admin.Token,
+			Server: s,
+			Users:  users,
+			Stats: func() map[string]interface{} {
+				return map[string]interface{}{"file_transfers_active": fileTransfer.ActiveTransfers()}
+			},
+		})
+		if err != nil {
+			logger.Fatal("start admin api error", common.Err(err))
+		}
+		s.StartHTTPServer("admin", cfg.Admin.Address, api)
+	}
+	// Close返回前模块停止和HTTP关闭仍在进行，关闭存储前要等待它完成
+	var closeOnce sync.Once
+	closeServer := func() {
+		closeOnce.Do(func() { _ = s.Close() })
+	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		logger.Info("received signal, closing server", common.F("signal", sig))
+		closeServer()
+	}()
 	s.Serve()
+	closeServer()
+	if err := history.Close(); err != nil {
+		logger.Error("close message history error", common.Err(err))
+	}
+	if err := inbox.Close(); err != nil {
+		logger.Error("close inbox error", common.Err(err))
+	}
+	if err := accounts.Close(); err != nil {
+		logger.Error("close user store error", common.Err(err))
+	}
+}
+
+func openHistory(cfg config.StorageConfig) (store.HistoryStore, error) {
+	if cfg.History == "memory" || len(cfg.DataDir) == 0 {
+		return store.NewMemoryHistory(), nil
+	}
+	return store.OpenFileHistory(cfg.DataDir)
+}
+
+// openInbox DataDir为空时离线消息只保存在内存中
+func openInbox(cfg config.StorageConfig) (store.InboxStore, error) {
+	limits := store.InboxLimits{Limit: cfg.InboxLimit, TTL: time.Duration(cfg.InboxTTL)}
+	if len(cfg.DataDir) == 0 {
+		return store.NewMemoryInbox(limits), nil
+	}
+	return store.OpenFileInbox(cfg.DataDir, limits)
+}
+
+// openUsers DataDir为空时注册用户只保存在内存中，重启后丢失
+func openUsers(cfg config.StorageConfig) (store.UserStore, error) {
+	if len(cfg.DataDir) == 0 {
+		return store.NewMemoryUsers(), nil
+	}
+	return store.OpenFileUsers(cfg.DataDir)
+}
+
+// newAuthenticator 依次尝试握手令牌、客户端证书和登录消息中的昵称密码
+func newAuthenticator(cfg *config.Config, login func(common.Context, *common.RawMessage) (*common.Principal, error)) goserver.Authenticator {
+	authenticators := goserver.Authenticators{}
+	if len(cfg.Auth.Tokens) != 0 {
+		names := make(map[string]string, len(cfg.Auth.Tokens))
+		for _, token := range cfg.Auth.Tokens {
+			names[token.Token] = token.Name
+		}
+		authenticators = append(authenticators, &goserver.TokenAuthenticator{
+			Verify: func(token string) (*common.Principal, error) {
+				if len(token) == 0 {
+					return nil, nil
+				}
+				name, ok := names[token]
+				if !ok {
+					return nil, errors.New("invalid token")
+				}
+				return &common.Principal{Name: name, Method: "token"}, nil
+			},
+		})
+	}
+	if len(cfg.TLS.ClientCAFile) != 0 {
+		authenticators = append(authenticators, goserver.CertificateAuthenticator{})
+	}
+	return append(authenticators, &goserver.MessageAuthenticator{Code: enum.UserLogin, Authenticate: login})
+}
+
+// commandSpecs 服务端启动前命令目录还没有建立，直接从模块收集命令用于解析auth.policy
+func commandSpecs(modules ...common.Module) []common.CommandSpec {
+	specs := make([]common.CommandSpec, 0)
+	for _, module := range modules {
+		specs = append(specs, module.CommandSpecs()...)
+	}
+	return specs
 }
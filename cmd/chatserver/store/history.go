@@ -0,0 +1,122 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"gochat/common/message/msg"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// MaxHistoryPerRoom 每个聊天室在内存中保留的消息数，Recent最多返回这么多条
+	MaxHistoryPerRoom = 1000
+	HistoryFileName   = "history.jsonl"
+)
+
+// HistoryStore 保存聊天室消息
+type HistoryStore interface {
+	Append(entry msg.HistoryEntry) error
+	// Recent 按时间顺序返回聊天室最近的n条消息
+	Recent(room string, n int) ([]msg.HistoryEntry, error)
+	Close() error
+}
+
+// MemoryHistory 只保存在内存中，每个聊天室保留最近的MaxHistoryPerRoom条
+type MemoryHistory struct {
+	lock  sync.Mutex
+	rooms map[string][]msg.HistoryEntry
+}
+
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{rooms: make(map[string][]msg.HistoryEntry)}
+}
+
+func (h *MemoryHistory) Append(entry msg.HistoryEntry) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	entries := append(h.rooms[entry.Room], entry)
+	if len(entries) > MaxHistoryPerRoom {
+		entries = append([]msg.HistoryEntry(nil), entries[len(entries)-MaxHistoryPerRoom:]...)
+	}
+	h.rooms[entry.Room] = entries
+	return nil
+}
+
+func (h *MemoryHistory) Recent(room string, n int) ([]msg.HistoryEntry, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	entries := h.rooms[room]
+	if n < len(entries) {
+		entries = entries[len(entries)-n:]
+	}
+	return append([]msg.HistoryEntry(nil), entries...), nil
+}
+
+func (h *MemoryHistory) Close() error {
+	return nil
+}
+
+// FileHistory 以每行一条JSON的格式追加写入文件，打开时把每个聊天室最近的消息读入内存
+type FileHistory struct {
+	lock   sync.Mutex
+	file   *os.File
+	memory *MemoryHistory
+}
+
+// OpenFileHistory 打开dir下的历史文件，不存在时创建。
+// 进程异常退出可能留下不完整的最后一行，读取时会跳过无法解析的行
+func OpenFileHistory(dir string) (*FileHistory, error) {
+	path := filepath.Join(dir, HistoryFileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open history file error: %w", err)
+	}
+	h := &FileHistory{file: file, memory: NewMemoryHistory()}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		entry := msg.HistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		_ = h.memory.Append(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("read history file %s error: %w", path, err)
+	}
+	// 补上缺少的换行，避免新消息接在不完整的行后面
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			_, _ = file.Write([]byte{'\n'})
+		}
+	}
+	return h, nil
+}
+
+func (h *FileHistory) Append(entry msg.HistoryEntry) error {
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, err := h.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write history error: %w", err)
+	}
+	return h.memory.Append(entry)
+}
+
+func (h *FileHistory) Recent(room string, n int) ([]msg.HistoryEntry, error) {
+	return h.memory.Recent(room, n)
+}
+
+func (h *FileHistory) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.file.Close()
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gochat/common/message/msg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const InboxFileName = "inbox.json"
+
+// ErrInboxFull 收件箱中未读消息达到上限
+var ErrInboxFull = errors.New("inbox is full")
+
+// InboxStore 保存发给离线用户的消息，用户以用户名区分。
+// 注册用户才有收件箱，Limit和TTL由实现负责
+type InboxStore interface {
+	// Register 为用户创建收件箱，已存在时不做任何事
+	Register(user string) error
+	Registered(user string) (bool, error)
+	// Push 用户未注册时返回false
+	Push(user string, entry msg.InboxEntry) (bool, error)
+	// Take 取出并清空未过期的消息
+	Take(user string) ([]msg.InboxEntry, error)
+	// Restore 把取出后投递失败的消息放回收件箱开头，不受Limit限制
+	Restore(user string, entries []msg.InboxEntry) error
+	Close() error
+}
+
+// InboxLimits 每个用户最多保存Limit条，超过TTL的消息不再投递，0表示不限制
+type InboxLimits struct {
+	Limit int
+	TTL   time.Duration
+}
+
+// MemoryInbox 只保存在内存中
+type MemoryInbox struct {
+	lock   sync.Mutex
+	limits InboxLimits
+	users  map[string][]msg.InboxEntry
+	// onChange 修改后调用，FileInbox用来写入文件，调用时持有锁
+	onChange func(users map[string][]msg.InboxEntry) error
+}
+
+func NewMemoryInbox(limits InboxLimits) *MemoryInbox {
+	return &MemoryInbox{limits: limits, users: make(map[string][]msg.InboxEntry)}
+}
+
+func (b *MemoryInbox) Register(user string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.users[user]; ok {
+		return nil
+	}
+	b.users[user] = []msg.InboxEntry{}
+	if err := b.changed(); err != nil {
+		delete(b.users, user)
+		return err
+	}
+	return nil
+}
+
+func (b *MemoryInbox) Registered(user string) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, ok := b.users[user]
+	return ok, nil
+}
+
+func (b *MemoryInbox) Push(user string, entry msg.InboxEntry) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	entries, ok := b.users[user]
+	if !ok {
+		return false, nil
+	}
+	entries = b.unexpired(entries, entry.Time)
+	if b.limits.Limit > 0 && len(entries) >= b.limits.Limit {
+		return true, ErrInboxFull
+	}
+	old := b.users[user]
+	b.users[user] = append(entries, entry)
+	if err := b.changed(); err != nil {
+		b.users[user] = old
+		return true, err
+	}
+	return true, nil
+}
+
+func (b *MemoryInbox) Take(user string) ([]msg.InboxEntry, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	entries, ok := b.users[user]
+	if !ok || len(entries) == 0 {
+		return nil, nil
+	}
+	b.users[user] = []msg.InboxEntry{}
+	if err := b.changed(); err != nil {
+		b.users[user] = entries
+		return nil, err
+	}
+	return b.unexpired(entries, time.Now()), nil
+}
+
+func (b *MemoryInbox) Restore(user string, entries []msg.InboxEntry) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	old, ok := b.users[user]
+	if !ok || len(entries) == 0 {
+		return nil
+	}
+	b.users[user] = append(append([]msg.InboxEntry(nil), entries...), old...)
+	if err := b.changed(); err != nil {
+		b.users[user] = old
+		return err
+	}
+	return nil
+}
+
+func (b *MemoryInbox) Close() error {
+	return nil
+}
+
+func (b *MemoryInbox) unexpired(entries []msg.InboxEntry, now time.Time) []msg.InboxEntry {
+	if b.limits.TTL <= 0 {
+		return entries
+	}
+	kept := make([]msg.InboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		if now.Sub(entry.Time) < b.limits.TTL {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+func (b *MemoryInbox) changed() error {
+	if b.onChange == nil {
+		return nil
+	}
+	return b.onChange(b.users)
+}
+
+// FileInbox 每次修改后把全部收件箱写入dir下的JSON文件，先写临时文件再重命名
+type FileInbox struct {
+	*MemoryInbox
+}
+
+func OpenFileInbox(dir string, limits InboxLimits) (*FileInbox, error) {
+	path := filepath.Join(dir, InboxFileName)
+	inbox := NewMemoryInbox(limits)
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read inbox file error: %w", err)
+	}
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &inbox.users); err != nil {
+			return nil, fmt.Errorf("parse inbox file %s error: %w", path, err)
+		}
+	}
+	inbox.onChange = func(users map[string][]msg.InboxEntry) error {
+		return writeFileAtomic(path, users)
+	}
+	return &FileInbox{MemoryInbox: inbox}, nil
+}
+
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write %s error: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("rename %s error: %w", tmp, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"errors"
+	"gochat/common/message/msg"
+	"testing"
+	"time"
+)
+
+func entry(text string, at time.Time) msg.InboxEntry {
+	return msg.InboxEntry{From: "1", FromNickName: "bob", Text: text, Time: at}
+}
+
+func texts(entries []msg.InboxEntry) []string {
+	result := make([]string, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.Text)
+	}
+	return result
+}
+
+func TestMemoryInboxLimit(t *testing.T) {
+	inbox := NewMemoryInbox(InboxLimits{Limit: 2})
+	now := time.Now()
+	if queued, err := inbox.Push("alice", entry("a", now)); queued || err != nil {
+		t.Fatalf("Push() to unregistered user = %v, %v, want false, nil", queued, err)
+	}
+	if err := inbox.Register("alice"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text    string
+		wantErr error
+	}{
+		{"a", nil},
+		{"b", nil},
+		{"c", ErrInboxFull},
+	}
+	for _, tt := range tests {
+		queued, err := inbox.Push("alice", entry(tt.text, now))
+		if !queued || !errors.Is(err, tt.wantErr) {
+			t.Errorf("Push(%s) = %v, %v, want true, %v", tt.text, queued, err, tt.wantErr)
+		}
+	}
+	entries, err := inbox.Take("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(entries); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Take() = %q, want [a b]", got)
+	}
+	if queued, err := inbox.Push("alice", entry("d", now)); !queued || err != nil {
+		t.Errorf("Push() after Take = %v, %v, want true, nil", queued, err)
+	}
+}
+
+func TestMemoryInboxTTL(t *testing.T) {
+	inbox := NewMemoryInbox(InboxLimits{Limit: 2, TTL: time.Hour})
+	if err := inbox.Register("alice"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, e := range []msg.InboxEntry{entry("old", now.Add(-2*time.Hour)), entry("new", now)} {
+		if _, err := inbox.Push("alice", e); err != nil {
+			t.Fatalf("Push(%s) error = %v", e.Text, err)
+		}
+	}
+	// 过期的消息不占用名额
+	if _, err := inbox.Push("alice", entry("newer", now)); err != nil {
+		t.Fatalf("Push() with an expired entry should not be full, got %v", err)
+	}
+	entries, err := inbox.Take("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(entries); len(got) != 2 || got[0] != "new" || got[1] != "newer" {
+		t.Errorf("Take() = %q, want [new newer]", got)
+	}
+}
+
+func TestMemoryInboxRollback(t *testing.T) {
+	inbox := NewMemoryInbox(InboxLimits{})
+	if err := inbox.Register("alice"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := inbox.Push("alice", entry("a", now)); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("disk full")
+	inbox.onChange = func(map[string][]msg.InboxEntry) error { return failure }
+	if _, err := inbox.Push("alice", entry("b", now)); !errors.Is(err, failure) {
+		t.Fatalf("Push() error = %v, want %v", err, failure)
+	}
+	if _, err := inbox.Take("alice"); !errors.Is(err, failure) {
+		t.Fatalf("Take() error = %v, want %v", err, failure)
+	}
+	if err := inbox.Register("carol"); !errors.Is(err, failure) {
+		t.Fatalf("Register() error = %v, want %v", err, failure)
+	}
+	inbox.onChange = nil
+	if ok, _ := inbox.Registered("carol"); ok {
+		t.Error("failed Register() should be rolled back")
+	}
+	entries, err := inbox.Take("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(entries); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("Take() after failures = %q, want [a]", got)
+	}
+	if _, err := inbox.Push("alice", entry("c", now)); err != nil {
+		t.Fatal(err)
+	}
+	if err := inbox.Restore("alice", entries); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = inbox.Take("alice")
+	if got := texts(entries); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("Take() after Restore = %q, want [a c]", got)
+	}
+}
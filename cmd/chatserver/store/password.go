@@ -0,0 +1,76 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	PasswordAlgorithm = "pbkdf2-sha256"
+	// PasswordIterations 新密码使用的迭代次数，旧密码按保存时的次数校验
+	PasswordIterations = 310000
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+)
+
+// PasswordHash 加盐后的密码摘要
+type PasswordHash struct {
+	Algorithm  string `json:"algorithm"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Hash       []byte `json:"hash"`
+}
+
+// HashPassword 使用随机盐和PBKDF2-SHA256计算密码摘要
+func HashPassword(password string) (PasswordHash, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return PasswordHash{}, fmt.Errorf("generate salt error: %w", err)
+	}
+	return PasswordHash{
+		Algorithm:  PasswordAlgorithm,
+		Iterations: PasswordIterations,
+		Salt:       salt,
+		Hash:       pbkdf2SHA256([]byte(password), salt, PasswordIterations, passwordKeySize),
+	}, nil
+}
+
+// Verify 比较时间与密码内容无关
+func (p PasswordHash) Verify(password string) bool {
+	if p.Algorithm != PasswordAlgorithm || p.Iterations <= 0 || len(p.Hash) == 0 {
+		return false
+	}
+	hash := pbkdf2SHA256([]byte(password), p.Salt, p.Iterations, len(p.Hash))
+	return subtle.ConstantTimeCompare(hash, p.Hash) == 1
+}
+
+// pbkdf2SHA256 按RFC 8018实现PBKDF2，PRF为HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (keyLen + sha256.Size - 1) / sha256.Size
+	key := make([]byte, 0, blocks*sha256.Size)
+	counter := make([]byte, 4)
+	u := make([]byte, sha256.Size)
+	for block := 1; block <= blocks; block++ {
+		binary.BigEndian.PutUint32(counter, uint32(block))
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter)
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// 测试向量与Python的hashlib.pbkdf2_hmac('sha256', ...)结果一致
+func TestPBKDF2SHA256(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 40,
+			"348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, 16, "89b69d0516f829893c696226650a8687"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, tt.keyLen, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordHashVerify(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if hash.Algorithm != PasswordAlgorithm || hash.Iterations != PasswordIterations ||
+		len(hash.Salt) != passwordSaltSize || len(hash.Hash) != passwordKeySize {
+		t.Fatalf("unexpected hash parameters %+v", hash)
+	}
+	other, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if hex.EncodeToString(other.Salt) == hex.EncodeToString(hash.Salt) {
+		t.Error("salt must be random")
+	}
+
+	data, err := json.Marshal(hash)
+	if err != nil {
+		t.Fatalf("marshal error = %v", err)
+	}
+	saved := PasswordHash{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("unmarshal error = %v", err)
+	}
+
+	tampered := saved
+	tampered.Hash = append([]byte(nil), saved.Hash...)
+	tampered.Hash[0] ^= 1
+	unknown := saved
+	unknown.Algorithm = "md5"
+	tests := []struct {
+		name     string
+		hash     PasswordHash
+		password string
+		want     bool
+	}{
+		{"correct", saved, "correct horse", true},
+		{"wrong", saved, "correct horse!", false},
+		{"empty", saved, "", false},
+		{"tampered", tampered, "correct horse", false},
+		{"unknown algorithm", unknown, "correct horse", false},
+		{"zero value", PasswordHash{}, "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.hash.Verify(tt.password); got != tt.want {
+			t.Errorf("%s: Verify() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
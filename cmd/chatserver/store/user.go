@@ -0,0 +1,131 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const UserFileName = "users.json"
+
+// ErrUserExists 注册的用户名已被使用
+var ErrUserExists = errors.New("user already exists")
+
+// Account 注册用户，ID在注册时生成且不会改变
+type Account struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Password  PasswordHash `json:"password"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// UserStore 保存注册用户，以用户名查找
+type UserStore interface {
+	// Create 用户名已存在时返回ErrUserExists
+	Create(account Account) error
+	Get(name string) (Account, bool, error)
+	Update(account Account) error
+	Close() error
+}
+
+// NewAccount 生成随机ID并计算密码摘要
+func NewAccount(name, password string) (Account, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Account{}, fmt.Errorf("generate user id error: %w", err)
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return Account{}, err
+	}
+	return Account{ID: hex.EncodeToString(id), Name: name, Password: hash, CreatedAt: time.Now()}, nil
+}
+
+// MemoryUsers 只保存在内存中
+type MemoryUsers struct {
+	lock     sync.Mutex
+	accounts map[string]Account
+	// onChange 修改后调用，FileUsers用来写入文件，调用时持有锁
+	onChange func(accounts map[string]Account) error
+}
+
+func NewMemoryUsers() *MemoryUsers {
+	return &MemoryUsers{accounts: make(map[string]Account)}
+}
+
+func (u *MemoryUsers) Create(account Account) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if _, ok := u.accounts[account.Name]; ok {
+		return ErrUserExists
+	}
+	u.accounts[account.Name] = account
+	if err := u.changed(); err != nil {
+		delete(u.accounts, account.Name)
+		return err
+	}
+	return nil
+}
+
+func (u *MemoryUsers) Get(name string) (Account, bool, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	account, ok := u.accounts[name]
+	return account, ok, nil
+}
+
+func (u *MemoryUsers) Update(account Account) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	old, ok := u.accounts[account.Name]
+	if !ok {
+		return fmt.Errorf("user %s not found", account.Name)
+	}
+	u.accounts[account.Name] = account
+	if err := u.changed(); err != nil {
+		u.accounts[account.Name] = old
+		return err
+	}
+	return nil
+}
+
+func (u *MemoryUsers) Close() error {
+	return nil
+}
+
+func (u *MemoryUsers) changed() error {
+	if u.onChange == nil {
+		return nil
+	}
+	return u.onChange(u.accounts)
+}
+
+// FileUsers 每次修改后把全部用户写入dir下的JSON文件
+type FileUsers struct {
+	*MemoryUsers
+}
+
+func OpenFileUsers(dir string) (*FileUsers, error) {
+	path := filepath.Join(dir, UserFileName)
+	users := NewMemoryUsers()
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read user file error: %w", err)
+	}
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &users.accounts); err != nil {
+			return nil, fmt.Errorf("parse user file %s error: %w", path, err)
+		}
+	}
+	users.onChange = func(accounts map[string]Account) error {
+		return writeFileAtomic(path, accounts)
+	}
+	return &FileUsers{MemoryUsers: users}, nil
+}
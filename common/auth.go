@@ -0,0 +1,43 @@
+package common
+
+// Principal 连接通过认证后的身份
+type Principal struct {
+	// ID 为空时由应用自行分配
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	// Method 认证方式，例如token、password、certificate
+	Method string `json:"method"`
+	// Roles 由应用分配，Authorizer据此判断权限
+	Roles []string `json:"roles,omitempty"`
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// PrincipalContext 由支持认证的Context实现，身份附加在连接上
+type PrincipalContext interface {
+	Principal() *Principal
+	// SetPrincipal 设置为nil表示取消认证，例如用户登出
+	SetPrincipal(principal *Principal)
+}
+
+// PrincipalOf 返回连接的身份，未认证或Context不支持认证时返回nil
+func PrincipalOf(ctx Context) *Principal {
+	if c, ok := ctx.(PrincipalContext); ok {
+		return c.Principal()
+	}
+	return nil
+}
+
+var (
+	// ErrUnauthenticated 连接未认证时发送了需要认证的消息
+	ErrUnauthenticated = NewCodeError(ErrCodeUnauthorized, "authentication required")
+	// ErrPermissionDenied 身份没有发送该消息的权限
+	ErrPermissionDenied = NewCodeError(ErrCodeForbidden, "permission denied")
+)
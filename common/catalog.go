@@ -0,0 +1,80 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ArgSpec 命令的位置参数，Type为string、int、bool、duration之一，空表示string
+type ArgSpec struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+	Variadic bool   `json:"variadic,omitempty"`
+	Help     string `json:"help,omitempty"`
+	// Complete 交互输入时的补全方式: user、file、command，空表示不补全
+	Complete string `json:"complete,omitempty"`
+	// Secret 包含该参数的输入不保存到客户端的输入历史
+	Secret bool `json:"secret,omitempty"`
+}
+
+// FlagSpec 命令的命名参数
+type FlagSpec struct {
+	Name    string `json:"name"`
+	Type    string `json:"type,omitempty"`
+	Default string `json:"default,omitempty"`
+	Help    string `json:"help,omitempty"`
+}
+
+// CommandSpec 服务端公布给客户端的用户命令，客户端按声明解析参数后向Code发送消息。
+// Payload为空时消息体是以参数名为键的JSON对象，没有参数时没有消息体；
+// Payload为某个参数名时消息体就是该参数的值
+type CommandSpec struct {
+	Name    string      `json:"name"`
+	Alias   []string    `json:"alias,omitempty"`
+	Code    MessageCode `json:"code"`
+	Args    []ArgSpec   `json:"args,omitempty"`
+	Flags   []FlagSpec  `json:"flags,omitempty"`
+	Payload string      `json:"payload,omitempty"`
+	Help    string      `json:"help,omitempty"`
+}
+
+// CommandCatalog 由Env实现，返回已启动模块公布的全部命令
+type CommandCatalog interface {
+	Commands() []CommandSpec
+}
+
+// Validate 检查命令声明，消息码必须已注册且允许客户端发送
+func (s CommandSpec) Validate() error {
+	if len(s.Name) == 0 {
+		return fmt.Errorf("command of code %s has no name", s.Code)
+	}
+	info, ok := LookupCode(s.Code)
+	if !ok {
+		return fmt.Errorf("command %s targets unregistered code %d", s.Name, s.Code)
+	}
+	if info.Direction&ClientToServer == 0 {
+		return fmt.Errorf("command %s targets %s which clients can not send", s.Name, info.Name)
+	}
+	if len(s.Payload) == 0 {
+		return nil
+	}
+	for _, arg := range s.Args {
+		if arg.Name == s.Payload {
+			return nil
+		}
+	}
+	for _, flag := range s.Flags {
+		if flag.Name == s.Payload {
+			return nil
+		}
+	}
+	return fmt.Errorf("payload %q of command %s is not an argument", s.Payload, s.Name)
+}
+
+// SortCommands 按名称排序
+func SortCommands(specs []CommandSpec) {
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+}
@@ -1,22 +1,35 @@
 package common
 
-import "net"
+import (
+	"net"
+	"sync"
+	"time"
+)
 
 type Channel interface {
 	Write(*Message) error
 	Close() error
 	Read() (*RawMessage, error)
+	Unmarshal(data []byte, v interface{}) error
+	SetReadDeadline(t time.Time) error
 }
 
 type SimpleChannelImpl struct {
-	codec Codec
-	conn  net.Conn
+	codec     Codec
+	conn      net.Conn
+	writeLock sync.Mutex
 }
 
 func (c *SimpleChannelImpl) Write(msg *Message) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
 	return c.codec.Encode(msg)
 }
 
+func (c *SimpleChannelImpl) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
 func (c *SimpleChannelImpl) Close() error {
 	return c.conn.Close()
 }
@@ -26,6 +39,10 @@ func (c *SimpleChannelImpl) Read() (*RawMessage, error) {
 	return message, c.codec.Decode(message)
 }
 
+func (c *SimpleChannelImpl) Unmarshal(data []byte, v interface{}) error {
+	return c.codec.Unmarshal(data, v)
+}
+
 func NewSimpleChannel(codec Codec, conn net.Conn) *SimpleChannelImpl {
 	return &SimpleChannelImpl{
 		codec: codec,
@@ -14,6 +14,8 @@ const (
 type Codec interface {
 	Encode(interface{}) error
 	Decode(interface{}) error
+	// Unmarshal 按该编码格式解析RawMessage.RawData
+	Unmarshal([]byte, interface{}) error
 }
 
 type JsonCodec struct {
@@ -28,11 +30,19 @@ func NewJsonCodec(readWriter io.ReadWriter) Codec {
 	}
 }
 
-func GetCodec(codecType int8, reader io.ReadWriter) (Codec, error)  {
+func (c *JsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func IsSupportedCodec(codecType CodecType) bool {
+	return codecType == JsonCodecType
+}
+
+func GetCodec(codecType int8, reader io.ReadWriter) (Codec, error) {
 	switch codecType {
 	case JsonCodecType:
 		return NewJsonCodec(reader), nil
 	default:
 		return nil, errors.New("invalid codec type")
 	}
-}
\ No newline at end of file
+}
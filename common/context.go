@@ -1,8 +1,51 @@
 package common
 
+import "context"
+
 type Context interface {
 	RemoteAddr() string
 	LocalAddr() string
+	// Context 连接关闭时被取消，在OnMessage中返回的是本次消息分发的context，可能带有超时
+	Context() context.Context
+	// Value 读取连接上保存的属性，同一连接的所有Context共享属性
+	Value(key interface{}) interface{}
+	SetValue(key, value interface{})
+	// Logger 带有连接信息的子logger，在OnMessage中还带有消息码和请求ID
+	Logger() Logger
+	// AddLogFields 为该连接之后的日志追加字段，如登录后的用户ID
+	AddLogFields(fields ...Field)
 	Env
 	Channel
 }
+
+// RequestIDContext 处理消息期间的Context实现该接口
+type RequestIDContext interface {
+	RequestID() int64
+}
+
+// RequestIDOf 返回正在处理的消息的RequestID，不在消息处理中时返回0
+func RequestIDOf(ctx Context) int64 {
+	if c, ok := ctx.(RequestIDContext); ok {
+		return c.RequestID()
+	}
+	return 0
+}
+
+// ConnectionContext 处理消息期间的Context实现该接口，返回连接级的Context
+type ConnectionContext interface {
+	Connection() Context
+}
+
+// ConnectionOf 返回连接级的Context，需要在消息处理结束后继续持有Context时使用，
+// 避免持有已取消的消息context和本次请求的RequestID、日志字段
+func ConnectionOf(ctx Context) Context {
+	if c, ok := ctx.(ConnectionContext); ok {
+		return c.Connection()
+	}
+	return ctx
+}
+
+// Reply 回复正在处理的消息，回复带上请求的RequestID
+func Reply(ctx Context, code MessageCode, data interface{}) error {
+	return ctx.Write(&Message{Code: code, RawData: data, RequestID: RequestIDOf(ctx)})
+}
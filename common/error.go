@@ -0,0 +1,81 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorMessageCode 框架保留的消息码，handler返回Error时自动回复给对端
+const ErrorMessageCode MessageCode = -1
+
+type ErrCode int32
+
+const (
+	ErrCodeBadRequest   ErrCode = 400
+	ErrCodeUnauthorized ErrCode = 401
+	ErrCodeForbidden    ErrCode = 403
+	ErrCodeNotFound     ErrCode = 404
+	ErrCodeConflict     ErrCode = 409
+	ErrCodeInternal     ErrCode = 500
+)
+
+// CodeError handler返回该类型错误时，服务端会把它作为ErrorMessage回复给请求方
+type CodeError struct {
+	Code    ErrCode
+	Message string
+}
+
+func NewCodeError(code ErrCode, message string) *CodeError {
+	return &CodeError{Code: code, Message: message}
+}
+
+func CodeErrorf(code ErrCode, format string, args ...interface{}) *CodeError {
+	return &CodeError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("code=%d, message=%s", e.Code, e.Message)
+}
+
+// AsCodeError 判断err是否为(或包装了)*CodeError
+func AsCodeError(err error) (*CodeError, bool) {
+	var e *CodeError
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+type ErrorMessage struct {
+	Code      ErrCode `json:"code"`
+	Message   string  `json:"message"`
+	RequestID int64   `json:"request_id"`
+}
+
+func NewErrorMessage(requestID int64, err *CodeError) *Message {
+	return &Message{
+		Code: ErrorMessageCode,
+		RawData: &ErrorMessage{
+			Code:      err.Code,
+			Message:   err.Message,
+			RequestID: requestID,
+		},
+	}
+}
+
+type errorHandler struct {
+	BaseHandler
+	display func(msg *ErrorMessage) error
+}
+
+func NewErrorHandler(display func(msg *ErrorMessage) error) *errorHandler {
+	return &errorHandler{display: display}
+}
+
+func (h *errorHandler) OnMessage(ctx Context, message *RawMessage) error {
+	msg := &ErrorMessage{}
+	if err := ctx.Unmarshal(message.RawData, msg); err != nil {
+		return err
+	}
+	return h.display(msg)
+}
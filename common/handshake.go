@@ -0,0 +1,114 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const DefaultHandshakeTimeout = time.Second * 5
+
+const (
+	HandshakeOK byte = iota
+	HandshakeInvalidMagic
+	HandshakeUnsupportedCodec
+	HandshakeUnauthorized
+)
+
+const ackSize = 9
+
+type HandshakeError struct {
+	Status byte
+}
+
+func (e *HandshakeError) Error() string {
+	switch e.Status {
+	case HandshakeInvalidMagic:
+		return "handshake rejected: invalid magic number"
+	case HandshakeUnsupportedCodec:
+		return "handshake rejected: unsupported codec"
+	case HandshakeUnauthorized:
+		return "handshake rejected: unauthorized"
+	default:
+		return fmt.Sprintf("handshake rejected: status=%d", e.Status)
+	}
+}
+
+// ServerHandshake 在timeout内读取客户端握手头并回复确认帧，verify用于校验令牌，可以为nil
+func ServerHandshake(conn net.Conn, timeout time.Duration, verify func(header *Header) error) (*Header, error) {
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+	header, err := ReadHeader(conn)
+	if err != nil {
+		return nil, err
+	}
+	status := HandshakeOK
+	var verifyErr error
+	switch {
+	case header.Validate() != nil:
+		status = HandshakeInvalidMagic
+	case !IsSupportedCodec(header.CodecType):
+		status = HandshakeUnsupportedCodec
+	case verify != nil:
+		if verifyErr = verify(header); verifyErr != nil {
+			status = HandshakeUnauthorized
+		}
+	}
+	if err := writeAck(conn, status); err != nil {
+		return nil, err
+	}
+	if status != HandshakeOK {
+		if verifyErr != nil {
+			return nil, fmt.Errorf("%s: %w", &HandshakeError{Status: status}, verifyErr)
+		}
+		return nil, &HandshakeError{Status: status}
+	}
+	return header, nil
+}
+
+// ClientHandshake 发送握手头并在timeout内等待服务端确认
+func ClientHandshake(conn net.Conn, header *Header, timeout time.Duration) error {
+	if len(header.Token) > MaxTokenLength {
+		return errors.New("token too long")
+	}
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+	if _, err := conn.Write(header.Bytes()); err != nil {
+		return err
+	}
+	ack := make([]byte, ackSize)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return fmt.Errorf("read handshake ack error: %w", err)
+	}
+	if getInt64(ack) != MagicNumber {
+		return errors.New("invalid handshake ack")
+	}
+	if ack[8] != HandshakeOK {
+		return &HandshakeError{Status: ack[8]}
+	}
+	return nil
+}
+
+func writeAck(writer io.Writer, status byte) error {
+	ack := make([]byte, ackSize)
+	putInt64(ack, MagicNumber)
+	ack[8] = status
+	_, err := writer.Write(ack)
+	return err
+}
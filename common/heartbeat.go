@@ -0,0 +1,229 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+type HeartbeatConfig struct {
+	PingCode MessageCode
+	PongCode MessageCode
+	// Interval 发送ping的间隔
+	Interval time.Duration
+	// Timeout 超过该时间没有收到对端的心跳则关闭连接，应大于Interval
+	Timeout time.Duration
+}
+
+type HeartbeatMessage struct {
+	SentAt int64 `json:"sent_at"`
+}
+
+type HeartbeatStat struct {
+	RemoteAddr string
+	Latency    time.Duration
+	LastSeen   time.Time
+}
+
+type heartbeatKey struct{}
+
+type heartbeatState struct {
+	ctx      Context
+	lock     sync.Mutex
+	latency  time.Duration
+	lastSeen time.Time
+}
+
+// Heartbeat 服务端和客户端共用的心跳模块，双方定时互发ping并以pong回复，
+// 每次收到心跳都会延长连接的读超时，对端超过Timeout没有心跳时读取失败从而关闭连接
+type Heartbeat struct {
+	BaseModule
+	config HeartbeatConfig
+	lock   sync.Mutex
+	states map[*heartbeatState]struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewHeartbeat(config HeartbeatConfig) *Heartbeat {
+	return &Heartbeat{
+		config: config,
+		states: make(map[*heartbeatState]struct{}),
+	}
+}
+
+func (h *Heartbeat) Name() string {
+	return "heartbeat"
+}
+
+func (h *Heartbeat) Handlers() map[MessageCode]Handler {
+	return map[MessageCode]Handler{
+		h.config.PingCode: &heartbeatPingHandler{h: h},
+		h.config.PongCode: &heartbeatPongHandler{h: h},
+	}
+}
+
+func (h *Heartbeat) Init(_ Env) error {
+	if h.config.Interval <= 0 || h.config.Timeout <= h.config.Interval {
+		return errors.New("heartbeat timeout must be greater than interval")
+	}
+	return nil
+}
+
+func (h *Heartbeat) Start() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.done = make(chan struct{})
+	return nil
+}
+
+// Stop 未启动或已停止时不做任何事
+func (h *Heartbeat) Stop() error {
+	h.lock.Lock()
+	if h.done != nil {
+		close(h.done)
+		h.done = nil
+	}
+	h.lock.Unlock()
+	h.wg.Wait()
+	return nil
+}
+
+// Latency 返回连接最近一次测得的往返延迟
+func (h *Heartbeat) Latency(ctx Context) (time.Duration, bool) {
+	state, ok := ctx.Value(heartbeatKey{}).(*heartbeatState)
+	if !ok {
+		return 0, false
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.latency, state.latency > 0
+}
+
+func (h *Heartbeat) Stats() []HeartbeatStat {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	stats := make([]HeartbeatStat, 0, len(h.states))
+	for state := range h.states {
+		state.lock.Lock()
+		stats = append(stats, HeartbeatStat{
+			RemoteAddr: state.ctx.RemoteAddr(),
+			Latency:    state.latency,
+			LastSeen:   state.lastSeen,
+		})
+		state.lock.Unlock()
+	}
+	return stats
+}
+
+func (h *Heartbeat) active(ctx Context) {
+	state := &heartbeatState{ctx: ctx, lastSeen: time.Now()}
+	ctx.SetValue(heartbeatKey{}, state)
+	h.lock.Lock()
+	h.states[state] = struct{}{}
+	done := h.done
+	h.wg.Add(1)
+	h.lock.Unlock()
+	h.touch(state)
+	go pprof.Do(ctx.Context(), pprof.Labels("module", h.Name()), func(context.Context) {
+		h.ping(state, done)
+	})
+}
+
+func (h *Heartbeat) close(ctx Context) {
+	state, ok := ctx.Value(heartbeatKey{}).(*heartbeatState)
+	if !ok {
+		return
+	}
+	h.lock.Lock()
+	delete(h.states, state)
+	h.lock.Unlock()
+}
+
+// touch 收到心跳后延长读超时
+func (h *Heartbeat) touch(state *heartbeatState) {
+	state.lock.Lock()
+	state.lastSeen = time.Now()
+	state.lock.Unlock()
+	if err := state.ctx.SetReadDeadline(time.Now().Add(h.config.Timeout)); err != nil {
+		state.ctx.Logger().Error("[heartbeat] set read deadline error", Err(err))
+	}
+}
+
+func (h *Heartbeat) ping(state *heartbeatState, done chan struct{}) {
+	defer h.wg.Done()
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-state.ctx.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		err := state.ctx.Write(&Message{
+			Code:    h.config.PingCode,
+			RawData: &HeartbeatMessage{SentAt: time.Now().UnixNano()},
+		})
+		if err != nil {
+			state.ctx.Logger().Error("[heartbeat] ping error", Err(err))
+			_ = state.ctx.Close()
+			return
+		}
+	}
+}
+
+type heartbeatPingHandler struct {
+	BaseHandler
+	h *Heartbeat
+}
+
+func (p *heartbeatPingHandler) OnMessage(ctx Context, message *RawMessage) error {
+	if state, ok := ctx.Value(heartbeatKey{}).(*heartbeatState); ok {
+		p.h.touch(state)
+	}
+	ping := &HeartbeatMessage{}
+	if err := ctx.Unmarshal(message.RawData, ping); err != nil {
+		return NewCodeError(ErrCodeBadRequest, "invalid heartbeat")
+	}
+	return ctx.Write(&Message{
+		Code:    p.h.config.PongCode,
+		RawData: ping,
+	})
+}
+
+// heartbeatPongHandler 负责连接的心跳生命周期并计算往返延迟
+type heartbeatPongHandler struct {
+	BaseHandler
+	h *Heartbeat
+}
+
+func (p *heartbeatPongHandler) OnMessage(ctx Context, message *RawMessage) error {
+	state, ok := ctx.Value(heartbeatKey{}).(*heartbeatState)
+	if !ok {
+		return nil
+	}
+	p.h.touch(state)
+	pong := &HeartbeatMessage{}
+	if err := ctx.Unmarshal(message.RawData, pong); err != nil {
+		return NewCodeError(ErrCodeBadRequest, "invalid heartbeat")
+	}
+	if pong.SentAt > 0 {
+		state.lock.Lock()
+		state.latency = time.Since(time.Unix(0, pong.SentAt))
+		state.lock.Unlock()
+		ctx.Logger().Debug("[heartbeat] pong", F("latency", state.latency))
+	}
+	return nil
+}
+
+func (p *heartbeatPongHandler) OnActive(ctx Context) {
+	p.h.active(ctx)
+}
+
+func (p *heartbeatPongHandler) OnClose(ctx Context) {
+	p.h.close(ctx)
+}
@@ -1,8 +1,14 @@
 package common
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type LogLevel int8
@@ -11,45 +17,219 @@ const (
 	Debug LogLevel = iota + 1
 	Info
 	Error
+	fatal
 )
 
+func (l LogLevel) String() string {
+	switch l {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Error:
+		return "ERROR"
+	case fatal:
+		return "FATAL"
+	default:
+		return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return Debug, nil
+	case "info", "":
+		return Info, nil
+	case "error":
+		return Error, nil
+	default:
+		return 0, fmt.Errorf("invalid log level %q", level)
+	}
+}
+
+type LogFormat int8
+
+const (
+	TextFormat LogFormat = iota + 1
+	JsonFormat
+)
+
+func ParseLogFormat(format string) (LogFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "text", "":
+		return TextFormat, nil
+	case "json":
+		return JsonFormat, nil
+	default:
+		return 0, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
 type Logger interface {
-	Debug(...interface{})
-	Info(...interface{})
-	Error(...interface{})
-	Fatal(...interface{})
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// Fatal 记录日志后退出进程
+	Fatal(msg string, fields ...Field)
+	// With 返回带有固定字段的子logger
+	With(fields ...Field) Logger
 }
 
-type ConsoleLogger struct {
-	level LogLevel
+// StdLogger 以文本或JSON格式逐行输出日志，子logger与父logger共享输出和锁
+type StdLogger struct {
+	level      LogLevel
+	format     LogFormat
+	timeFormat string
+	out        io.Writer
+	lock       *sync.Mutex
+	fields     []Field
 }
 
-func NewConsoleLogger(level LogLevel) *ConsoleLogger {
-	return &ConsoleLogger{level: level}
+func NewLogger(out io.Writer, level LogLevel, format LogFormat) *StdLogger {
+	return &StdLogger{
+		level:      level,
+		format:     format,
+		timeFormat: "2006/01/02 15:04:05",
+		out:        out,
+		lock:       &sync.Mutex{},
+	}
 }
 
-func (l *ConsoleLogger) Debug(msg ...interface{}) {
-	if l.level > Debug {
-		return
+// SetTimeFormat 设置文本格式的时间格式，在创建子logger之前调用
+func (l *StdLogger) SetTimeFormat(layout string) {
+	l.timeFormat = layout
+}
+
+// ParseLogger 按名称解析日志级别和格式后创建logger
+func ParseLogger(out io.Writer, level, format string) (*StdLogger, error) {
+	logLevel, err := ParseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logFormat, err := ParseLogFormat(format)
+	if err != nil {
+		return nil, err
 	}
-	log.Println(msg)
+	return NewLogger(out, logLevel, logFormat), nil
 }
 
-func (l *ConsoleLogger) Info(msg ...interface{}) {
-	if l.level > Info {
+func NewConsoleLogger(level LogLevel) *StdLogger {
+	return NewLogger(os.Stderr, level, TextFormat)
+}
+
+func (l *StdLogger) Debug(msg string, fields ...Field) {
+	l.output(Debug, msg, fields)
+}
+
+func (l *StdLogger) Info(msg string, fields ...Field) {
+	l.output(Info, msg, fields)
+}
+
+func (l *StdLogger) Error(msg string, fields ...Field) {
+	l.output(Error, msg, fields)
+}
+
+func (l *StdLogger) Fatal(msg string, fields ...Field) {
+	l.output(fatal, msg, fields)
+	os.Exit(-1)
+}
+
+func (l *StdLogger) With(fields ...Field) Logger {
+	child := *l
+	child.fields = make([]Field, 0, len(l.fields)+len(fields))
+	child.fields = append(child.fields, l.fields...)
+	child.fields = append(child.fields, fields...)
+	return &child
+}
+
+func (l *StdLogger) output(level LogLevel, msg string, fields []Field) {
+	if level < l.level {
 		return
 	}
-	log.Println(msg)
+	now := time.Now()
+	var line []byte
+	if l.format == JsonFormat {
+		line = l.formatJson(now, level, msg, fields)
+	} else {
+		line = l.formatText(now, level, msg, fields)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, _ = l.out.Write(line)
 }
 
-func (l *ConsoleLogger) Error(msg ...interface{}) {
-	if l.level > Error {
-		return
+func (l *StdLogger) formatText(now time.Time, level LogLevel, msg string, fields []Field) []byte {
+	sb := &strings.Builder{}
+	sb.WriteString(now.Format(l.timeFormat))
+	sb.WriteString(" ")
+	sb.WriteString(level.String())
+	sb.WriteString(" ")
+	sb.WriteString(msg)
+	for _, list := range [][]Field{l.fields, fields} {
+		for _, field := range list {
+			sb.WriteString(" ")
+			sb.WriteString(field.Key)
+			sb.WriteString("=")
+			value := fmt.Sprint(fieldValue(field.Value))
+			if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			sb.WriteString(value)
+		}
 	}
-	log.Println(msg)
+	sb.WriteString("\n")
+	return []byte(sb.String())
 }
 
-func (l *ConsoleLogger) Fatal(msg ...interface{}) {
-	log.Println(msg)
-	os.Exit(-1)
+func (l *StdLogger) formatJson(now time.Time, level LogLevel, msg string, fields []Field) []byte {
+	sb := &strings.Builder{}
+	sb.WriteString(`{"time":`)
+	writeJsonValue(sb, now.Format(time.RFC3339Nano))
+	sb.WriteString(`,"level":`)
+	writeJsonValue(sb, level.String())
+	sb.WriteString(`,"msg":`)
+	writeJsonValue(sb, msg)
+	for _, list := range [][]Field{l.fields, fields} {
+		for _, field := range list {
+			sb.WriteString(",")
+			writeJsonValue(sb, field.Key)
+			sb.WriteString(":")
+			writeJsonValue(sb, fieldValue(field.Value))
+		}
+	}
+	sb.WriteString("}\n")
+	return []byte(sb.String())
+}
+
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return value
+	}
+}
+
+func writeJsonValue(sb *strings.Builder, value interface{}) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		bytes, _ = json.Marshal(fmt.Sprint(value))
+	}
+	sb.Write(bytes)
 }
@@ -1,14 +1,72 @@
 package enum
 
-import "gochat/common"
+import (
+	"gochat/common"
+	"gochat/common/message/msg"
+)
 
+// 消息码的值会在网络上传输，新增时请显式赋值，不要修改已有的值
 const (
-	Display common.MessageCode = iota + 1
-	UserLogin
-	UserLogout
-	GetOnlineUserList
-	Ping
-	Pong
-	SendMessage
-	FileTransfer
+	Display           common.MessageCode = 1
+	UserLogin         common.MessageCode = 2
+	UserLogout        common.MessageCode = 3
+	GetOnlineUserList common.MessageCode = 4
+	Ping              common.MessageCode = 5
+	Pong              common.MessageCode = 6
+	SendMessage       common.MessageCode = 7
+	FileTransfer      common.MessageCode = 8
+	DescribeProtocol  common.MessageCode = 9
+	OnlineUserList    common.MessageCode = 10
+	UserPresence      common.MessageCode = 11
+	CommandCatalog    common.MessageCode = 12
+	PrivateMessage    common.MessageCode = 13
+	RoomCreate        common.MessageCode = 14
+	RoomJoin          common.MessageCode = 15
+	RoomLeave         common.MessageCode = 16
+	RoomInvite        common.MessageCode = 17
+	GetRoomList       common.MessageCode = 18
+	RoomList          common.MessageCode = 19
+	GetRoomMembers    common.MessageCode = 20
+	RoomMembers       common.MessageCode = 21
+	RoomMessage       common.MessageCode = 22
+	GetHistory        common.MessageCode = 23
+	History           common.MessageCode = 24
+	Inbox             common.MessageCode = 25
+	UserRegister      common.MessageCode = 26
+	ChangePassword    common.MessageCode = 27
+	UserKick          common.MessageCode = 28
 )
+
+// 标记了RequireAuth的消息在登录认证之前会被服务端拒绝
+func init() {
+	common.RegisterCode(
+		common.CodeInfo{Code: Display, Name: "Display", Payload: "", Direction: common.Bidirectional},
+		common.CodeInfo{Code: UserLogin, Name: "UserLogin", Payload: msg.LoginMsg{}, Direction: common.ClientToServer},
+		common.CodeInfo{Code: UserLogout, Name: "UserLogout", Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: GetOnlineUserList, Name: "GetOnlineUserList", Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: Ping, Name: "Ping", Payload: common.HeartbeatMessage{}, Direction: common.Bidirectional},
+		common.CodeInfo{Code: Pong, Name: "Pong", Payload: common.HeartbeatMessage{}, Direction: common.Bidirectional},
+		common.CodeInfo{Code: SendMessage, Name: "SendMessage", Payload: "", Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: FileTransfer, Name: "FileTransfer", Payload: msg.FileTransformEntity{}, Direction: common.Bidirectional, RequireAuth: true},
+		common.CodeInfo{Code: DescribeProtocol, Name: "DescribeProtocol", Payload: []common.CodeSchema{}, Direction: common.Bidirectional},
+		common.CodeInfo{Code: OnlineUserList, Name: "OnlineUserList", Payload: msg.OnlineUserListMsg{}, Direction: common.ServerToClient},
+		common.CodeInfo{Code: UserPresence, Name: "UserPresence", Payload: msg.UserPresenceMsg{}, Direction: common.ServerToClient},
+		common.CodeInfo{Code: CommandCatalog, Name: "CommandCatalog", Payload: []common.CommandSpec{}, Direction: common.ServerToClient},
+		common.CodeInfo{Code: PrivateMessage, Name: "PrivateMessage", Payload: msg.PrivateMsg{}, Direction: common.Bidirectional, RequireAuth: true},
+		common.CodeInfo{Code: RoomCreate, Name: "RoomCreate", Payload: msg.RoomCreateMsg{}, Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: RoomJoin, Name: "RoomJoin", Payload: "", Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: RoomLeave, Name: "RoomLeave", Payload: "", Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: RoomInvite, Name: "RoomInvite", Payload: msg.RoomInviteMsg{}, Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: GetRoomList, Name: "GetRoomList", Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: RoomList, Name: "RoomList", Payload: msg.RoomListMsg{}, Direction: common.ServerToClient},
+		common.CodeInfo{Code: GetRoomMembers, Name: "GetRoomMembers", Payload: "", Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: RoomMembers, Name: "RoomMembers", Payload: msg.RoomMembersMsg{}, Direction: common.ServerToClient},
+		common.CodeInfo{Code: RoomMessage, Name: "RoomMessage", Payload: msg.RoomMsg{}, Direction: common.Bidirectional, RequireAuth: true},
+		common.CodeInfo{Code: GetHistory, Name: "GetHistory", Payload: msg.HistoryRequestMsg{}, Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: History, Name: "History", Payload: msg.HistoryMsg{}, Direction: common.ServerToClient},
+		common.CodeInfo{Code: Inbox, Name: "Inbox", Payload: msg.InboxMsg{}, Direction: common.ServerToClient},
+		common.CodeInfo{Code: UserRegister, Name: "UserRegister", Payload: msg.RegisterMsg{}, Direction: common.ClientToServer},
+		common.CodeInfo{Code: ChangePassword, Name: "ChangePassword", Payload: msg.ChangePasswordMsg{}, Direction: common.ClientToServer, RequireAuth: true},
+		common.CodeInfo{Code: UserKick, Name: "UserKick", Payload: msg.KickMsg{}, Direction: common.ClientToServer, RequireAuth: true},
+	)
+}
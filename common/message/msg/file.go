@@ -12,8 +12,8 @@ const (
 type FileTransformEntity struct {
 	FileSize int64
 	FileName string
-	To       string
-	From     string
+	To       string `validate:"required"`
+	From     string `validate:"required"`
 	Content  string
 	State    int8
 }
@@ -1,5 +1,64 @@
 package msg
 
+import "time"
+
+// LoginMsg 已注册的昵称必须提供密码，未注册的昵称以访客身份登录
 type LoginMsg struct {
-	NickName string
+	NickName string `validate:"required"`
+	Password string `json:",omitempty"`
+}
+
+// RegisterMsg 注册账号，昵称即用户名
+type RegisterMsg struct {
+	NickName string `json:"nickname" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ChangePasswordMsg struct {
+	OldPassword string `json:"old" validate:"required"`
+	NewPassword string `json:"new" validate:"required"`
+}
+
+// KickMsg 断开用户的所有连接，User可以是用户ID或昵称
+type KickMsg struct {
+	User   string `json:"user" validate:"required"`
+	Reason string `json:"reason"`
+}
+
+type OnlineUserInfo struct {
+	ID       string `json:"id"`
+	NickName string `json:"nickname"`
+}
+
+type OnlineUserListMsg struct {
+	Users []OnlineUserInfo `json:"users"`
+}
+
+// UserPresenceMsg 用户上线或下线时广播
+type UserPresenceMsg struct {
+	OnlineUserInfo
+	Online bool `json:"online"`
+}
+
+// PrivateMsg 私聊消息，To可以是用户ID或昵称。服务端转发时填写双方的ID和昵称
+type PrivateMsg struct {
+	To           string `json:"to" validate:"required"`
+	Text         string `json:"text" validate:"required"`
+	From         string `json:"from,omitempty"`
+	FromNickName string `json:"fromNickname,omitempty"`
+	ToNickName   string `json:"toNickname,omitempty"`
+}
+
+// InboxEntry 用户离线时收到的消息，Time为服务端收到消息的时间
+type InboxEntry struct {
+	From         string    `json:"from"`
+	FromNickName string    `json:"fromNickname"`
+	Text         string    `json:"text"`
+	Time         time.Time `json:"time"`
+}
+
+// InboxMsg 登录时投递离线期间收到的消息
+type InboxMsg struct {
+	Unread   int          `json:"unread"`
+	Messages []InboxEntry `json:"messages"`
 }
@@ -0,0 +1,61 @@
+package msg
+
+import "time"
+
+// RoomCreateMsg 创建聊天室，InviteOnly的聊天室只有被邀请的用户可以加入
+type RoomCreateMsg struct {
+	Name       string `json:"name" validate:"required"`
+	InviteOnly bool   `json:"invite"`
+}
+
+// RoomInviteMsg 邀请用户加入聊天室，User可以是用户ID或昵称
+type RoomInviteMsg struct {
+	Room string `json:"room" validate:"required"`
+	User string `json:"user" validate:"required"`
+}
+
+type RoomInfo struct {
+	Name       string `json:"name"`
+	InviteOnly bool   `json:"inviteOnly"`
+	Members    int    `json:"members"`
+	Joined     bool   `json:"joined"`
+}
+
+// RoomListMsg 对请求者可见的聊天室，不包含未被邀请的私有聊天室
+type RoomListMsg struct {
+	Rooms []RoomInfo `json:"rooms"`
+}
+
+type RoomMembersMsg struct {
+	Room    string           `json:"room"`
+	Members []OnlineUserInfo `json:"members"`
+}
+
+// RoomMsg 聊天室消息，服务端转发时填写发送者
+type RoomMsg struct {
+	Room         string `json:"room" validate:"required"`
+	Text         string `json:"text" validate:"required"`
+	From         string `json:"from,omitempty"`
+	FromNickName string `json:"fromNickname,omitempty"`
+}
+
+// HistoryEntry 服务端保存的一条聊天室消息，Time为服务端收到消息的时间
+type HistoryEntry struct {
+	Room         string    `json:"room"`
+	From         string    `json:"from"`
+	FromNickName string    `json:"fromNickname"`
+	Text         string    `json:"text"`
+	Time         time.Time `json:"time"`
+}
+
+// HistoryRequestMsg 请求聊天室最近的N条消息，N为0时使用服务端的默认值
+type HistoryRequestMsg struct {
+	Room string `json:"room" validate:"required"`
+	N    int    `json:"n"`
+}
+
+// HistoryMsg 按时间顺序排列的历史消息，登录和加入聊天室时也会发送
+type HistoryMsg struct {
+	Room     string         `json:"room"`
+	Messages []HistoryEntry `json:"messages"`
+}
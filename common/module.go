@@ -0,0 +1,177 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Module 把一组相关的handler和命令作为一个整体管理，可以整体启用或停用
+type Module interface {
+	Name() string
+	// Dependencies 依赖的模块名，依赖的模块会先于本模块启动
+	Dependencies() []string
+	Handlers() map[MessageCode]Handler
+	// CommandSpecs 模块启动后公布给客户端的命令，会加入服务端的命令目录
+	CommandSpecs() []CommandSpec
+	Init(env Env) error
+	Start() error
+	Stop() error
+}
+
+type BaseModule struct {
+}
+
+func (m *BaseModule) Dependencies() []string { return nil }
+
+func (m *BaseModule) Handlers() map[MessageCode]Handler { return nil }
+
+func (m *BaseModule) CommandSpecs() []CommandSpec { return nil }
+
+func (m *BaseModule) Init(_ Env) error { return nil }
+
+func (m *BaseModule) Start() error { return nil }
+
+func (m *BaseModule) Stop() error { return nil }
+
+// ModuleManager 按依赖顺序启动模块并注册其handler，停止时按相反顺序
+type ModuleManager struct {
+	env      Env
+	lock     sync.Mutex
+	modules  map[string]Module
+	names    []string
+	disabled map[string]bool
+	started  []Module
+}
+
+func NewModuleManager(env Env) *ModuleManager {
+	return &ModuleManager{
+		env:      env,
+		modules:  make(map[string]Module),
+		disabled: make(map[string]bool),
+	}
+}
+
+func (m *ModuleManager) Add(module Module) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(module.Name()) == 0 {
+		return errors.New("invalid module name")
+	}
+	if _, ok := m.modules[module.Name()]; ok {
+		return fmt.Errorf("duplicate module %s", module.Name())
+	}
+	m.modules[module.Name()] = module
+	m.names = append(m.names, module.Name())
+	return nil
+}
+
+// SetEnabled 在Start之前调用，停用的模块不会被启动
+func (m *ModuleManager) SetEnabled(name string, enabled bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.disabled[name] = !enabled
+}
+
+func (m *ModuleManager) Enabled(name string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, ok := m.modules[name]
+	return ok && !m.disabled[name]
+}
+
+// Names 返回所有已添加的模块名
+func (m *ModuleManager) Names() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]string(nil), m.names...)
+}
+
+// Start 按依赖顺序初始化并启动所有启用的模块，返回启动的模块
+func (m *ModuleManager) Start() ([]Module, error) {
+	m.lock.Lock()
+	ordered, err := m.resolve()
+	m.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, module := range ordered {
+		if err := module.Init(m.env); err != nil {
+			m.Stop()
+			return nil, fmt.Errorf("init module %s error: %w", module.Name(), err)
+		}
+		for code, handler := range module.Handlers() {
+			m.env.AddHandler(code, handler)
+		}
+		m.lock.Lock()
+		m.started = append(m.started, module)
+		m.lock.Unlock()
+		if err := module.Start(); err != nil {
+			m.Stop()
+			return nil, fmt.Errorf("start module %s error: %w", module.Name(), err)
+		}
+	}
+	return ordered, nil
+}
+
+// Stop 按启动的相反顺序停止模块并移除其handler
+func (m *ModuleManager) Stop() []error {
+	m.lock.Lock()
+	started := m.started
+	m.started = nil
+	m.lock.Unlock()
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		module := started[i]
+		if err := module.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("stop module %s error: %w", module.Name(), err))
+		}
+		for code := range module.Handlers() {
+			m.env.RemoveHandler(code)
+		}
+	}
+	return errs
+}
+
+func (m *ModuleManager) resolve() ([]Module, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	ordered := make([]Module, 0, len(m.names))
+	var visit func(name, from string) error
+	visit = func(name, from string) error {
+		module, ok := m.modules[name]
+		if !ok {
+			return fmt.Errorf("module %s depends on unknown module %s", from, name)
+		}
+		if m.disabled[name] {
+			return fmt.Errorf("module %s depends on disabled module %s", from, name)
+		}
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("module dependency cycle at %s", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range module.Dependencies() {
+			if err := visit(dep, name); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ordered = append(ordered, module)
+		return nil
+	}
+	for _, name := range m.names {
+		if m.disabled[name] {
+			continue
+		}
+		if err := visit(name, name); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
@@ -1,14 +1,16 @@
 package common
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"io"
-	"time"
 )
 
-const MagicNumber int64 = 0x10086
+const (
+	MagicNumber     int64 = 0x10086
+	headerFixedSize       = 11
+	MaxTokenLength        = 1024
+)
 
 type MessageCode int64
 type CodecType int8
@@ -16,6 +18,8 @@ type CodecType int8
 type Header struct {
 	MagicNumber int64
 	CodecType
+	// Token 可选的认证令牌，由服务端在握手时校验
+	Token string
 }
 
 func NewHeader(codecType CodecType) *Header {
@@ -32,63 +36,64 @@ func (h *Header) Validate() error {
 	return nil
 }
 
+// Bytes 8字节魔数 + 1字节编码类型 + 2字节令牌长度 + 令牌，均为小端序
 func (h *Header) Bytes() []byte {
-	number := h.MagicNumber
-	bytes := make([]byte, 9)
-	bytes[0] = (byte)(number)
-	bytes[1] = (byte)(number >> 8)
-	bytes[2] = (byte)(number >> 16)
-	bytes[3] = (byte)(number >> 24)
-	bytes[4] = (byte)(number >> 32)
-	bytes[5] = (byte)(number >> 40)
-	bytes[6] = (byte)(number >> 48)
-	bytes[7] = (byte)(number >> 56)
+	bytes := make([]byte, headerFixedSize+len(h.Token))
+	putInt64(bytes, h.MagicNumber)
 	bytes[8] = (byte)(h.CodecType)
+	bytes[9] = (byte)(len(h.Token))
+	bytes[10] = (byte)(len(h.Token) >> 8)
+	copy(bytes[headerFixedSize:], h.Token)
 	return bytes
 }
 
+// ReadHeader 读取完整的握手头，超时由调用方通过连接的deadline控制
 func ReadHeader(reader io.Reader) (*Header, error) {
-	var (
-		ctx, cancelFunc = context.WithTimeout(context.TODO(), time.Second*5)
-		c               = make(chan struct{}, 1)
-		bytes           = make([]byte, 9)
-		err             error
-		timeoutFlag     bool
-	)
-
-	go func() {
-		_, err = reader.Read(bytes)
-		c <- struct{}{}
-	}()
-	select {
-	case <-c:
-	case <-ctx.Done():
-		timeoutFlag = true
+	bytes := make([]byte, headerFixedSize)
+	if _, err := io.ReadFull(reader, bytes); err != nil {
+		return nil, err
 	}
-	cancelFunc()
-	if timeoutFlag {
-		return nil, errors.New("read header timeout")
+	header := &Header{
+		MagicNumber: getInt64(bytes),
+		CodecType:   CodecType(bytes[8]),
 	}
-	if err != nil {
-		return nil, err
+	tokenLength := int(bytes[9]) | int(bytes[10])<<8
+	if tokenLength > MaxTokenLength {
+		return nil, errors.New("token too long")
+	}
+	if tokenLength > 0 {
+		token := make([]byte, tokenLength)
+		if _, err := io.ReadFull(reader, token); err != nil {
+			return nil, err
+		}
+		header.Token = string(token)
 	}
-	magicNumber := int64(0)
+	return header, nil
+}
+
+func putInt64(bytes []byte, number int64) {
+	for i := 0; i < 8; i++ {
+		bytes[i] = (byte)(number >> (8 * i))
+	}
+}
+
+func getInt64(bytes []byte) int64 {
+	number := int64(0)
 	for i := 7; i >= 0; i-- {
-		magicNumber <<= 8
-		magicNumber = int64(bytes[i]) | magicNumber
+		number <<= 8
+		number = int64(bytes[i]) | number
 	}
-	return &Header{
-		MagicNumber: magicNumber,
-		CodecType:   CodecType(bytes[8]),
-	}, nil
+	return number
 }
 
 type RawMessage struct {
-	Code    MessageCode     `json:"code"`
-	RawData json.RawMessage `json:"raw_data"`
+	Code      MessageCode     `json:"code"`
+	RequestID int64           `json:"request_id,omitempty"`
+	RawData   json.RawMessage `json:"raw_data"`
 }
 
 type Message struct {
-	Code    MessageCode `json:"code"`
-	RawData interface{} `json:"raw_data"`
+	Code      MessageCode `json:"code"`
+	RequestID int64       `json:"request_id,omitempty"`
+	RawData   interface{} `json:"raw_data"`
 }
@@ -0,0 +1,193 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Direction int8
+
+const (
+	ClientToServer Direction = 1 << iota
+	ServerToClient
+	Bidirectional = ClientToServer | ServerToClient
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ClientToServer:
+		return "client->server"
+	case ServerToClient:
+		return "server->client"
+	case Bidirectional:
+		return "bidirectional"
+	default:
+		return fmt.Sprintf("direction(%d)", int8(d))
+	}
+}
+
+// CodeInfo 描述一个消息码，Payload为消息体的样例值，nil表示没有消息体
+type CodeInfo struct {
+	Code      MessageCode
+	Name      string
+	Payload   interface{}
+	Direction Direction
+	// RequireAuth 连接未认证时服务端直接拒绝该消息，不会交给handler
+	RequireAuth bool
+}
+
+type codeRegistry struct {
+	lock      sync.RWMutex
+	codes     map[MessageCode]CodeInfo
+	names     map[string]MessageCode
+	conflicts []string
+}
+
+var registry = &codeRegistry{
+	codes: make(map[MessageCode]CodeInfo),
+	names: make(map[string]MessageCode),
+}
+
+func init() {
+	RegisterCode(CodeInfo{Code: ErrorMessageCode, Name: "Error", Payload: ErrorMessage{}, Direction: ServerToClient})
+}
+
+// RegisterCode 注册消息码，编码或名称冲突会被记录下来，由CheckCodes在启动时统一报告
+func RegisterCode(infos ...CodeInfo) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	for _, info := range infos {
+		if old, ok := registry.codes[info.Code]; ok {
+			if old.Name != info.Name {
+				registry.conflicts = append(registry.conflicts,
+					fmt.Sprintf("code %d registered as both %s and %s", info.Code, old.Name, info.Name))
+			}
+			continue
+		}
+		if old, ok := registry.names[info.Name]; ok {
+			registry.conflicts = append(registry.conflicts,
+				fmt.Sprintf("name %s registered for both code %d and %d", info.Name, old, info.Code))
+			continue
+		}
+		registry.codes[info.Code] = info
+		registry.names[info.Name] = info.Code
+	}
+}
+
+// CheckCodes 返回注册时发现的所有冲突
+func CheckCodes() error {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	if len(registry.conflicts) == 0 {
+		return nil
+	}
+	return errors.New("message code conflict: " + strings.Join(registry.conflicts, "; "))
+}
+
+func LookupCode(code MessageCode) (CodeInfo, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	info, ok := registry.codes[code]
+	return info, ok
+}
+
+func LookupCodeByName(name string) (CodeInfo, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	code, ok := registry.names[name]
+	if !ok {
+		return CodeInfo{}, false
+	}
+	return registry.codes[code], true
+}
+
+// RegisteredCodes 按消息码排序返回所有已注册的消息码
+func RegisteredCodes() []CodeInfo {
+	registry.lock.RLock()
+	infos := make([]CodeInfo, 0, len(registry.codes))
+	for _, info := range registry.codes {
+		infos = append(infos, info)
+	}
+	registry.lock.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Code < infos[j].Code
+	})
+	return infos
+}
+
+func (c MessageCode) String() string {
+	if info, ok := LookupCode(c); ok {
+		return info.Name
+	}
+	return fmt.Sprintf("code(%d)", int64(c))
+}
+
+type FieldSchema struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+type CodeSchema struct {
+	Code      MessageCode   `json:"code"`
+	Name      string        `json:"name"`
+	Direction string        `json:"direction"`
+	Payload   string        `json:"payload,omitempty"`
+	Fields    []FieldSchema `json:"fields,omitempty"`
+	Auth      bool          `json:"auth,omitempty"`
+}
+
+// DescribeProtocol 根据注册表生成协议描述，用于回复客户端的协议查询
+func DescribeProtocol() []CodeSchema {
+	infos := RegisteredCodes()
+	schemas := make([]CodeSchema, 0, len(infos))
+	for _, info := range infos {
+		schema := CodeSchema{
+			Code:      info.Code,
+			Name:      info.Name,
+			Direction: info.Direction.String(),
+			Auth:      info.RequireAuth,
+		}
+		if info.Payload != nil {
+			payloadType := reflect.Indirect(reflect.ValueOf(info.Payload)).Type()
+			schema.Payload = payloadType.String()
+			if payloadType.Kind() == reflect.Struct {
+				for i := 0; i < payloadType.NumField(); i++ {
+					field := payloadType.Field(i)
+					if field.PkgPath != "" {
+						continue
+					}
+					schema.Fields = append(schema.Fields, FieldSchema{
+						Name:     field.Name,
+						Type:     field.Type.String(),
+						Required: field.Tag.Get("validate") == "required",
+					})
+				}
+			}
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas
+}
+
+type describeProtocolHandler struct {
+	BaseHandler
+	code MessageCode
+}
+
+// NewDescribeProtocolHandler 收到请求后把协议描述用同一个消息码回复
+func NewDescribeProtocolHandler(code MessageCode) *describeProtocolHandler {
+	return &describeProtocolHandler{code: code}
+}
+
+func (h *describeProtocolHandler) OnMessage(ctx Context, message *RawMessage) error {
+	return ctx.Write(&Message{
+		Code:      h.code,
+		RequestID: message.RequestID,
+		RawData:   DescribeProtocol(),
+	})
+}
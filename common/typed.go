@@ -0,0 +1,87 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	contextType = reflect.TypeOf((*Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// typedHandler 用连接协商的编码解析消息体后再调用业务函数
+type typedHandler struct {
+	BaseHandler
+	fn          reflect.Value
+	payloadType reflect.Type
+	isPointer   bool
+}
+
+// NewTypedHandler fn形如 func(ctx Context, payload *T) error 或 func(ctx Context, payload T) error，
+// 消息体会按连接的编码解析成T，并校验带有 validate:"required" 标签的字段
+func NewTypedHandler(fn interface{}) Handler {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	if fnType.Kind() != reflect.Func || fnType.NumIn() != 2 || fnType.NumOut() != 1 ||
+		fnType.In(0) != contextType || fnType.Out(0) != errorType {
+		panic(fmt.Sprintf("typed handler must be func(common.Context, T) error, got %s", fnType))
+	}
+	h := &typedHandler{fn: fnValue, payloadType: fnType.In(1)}
+	if h.payloadType.Kind() == reflect.Ptr {
+		h.payloadType = h.payloadType.Elem()
+		h.isPointer = true
+	}
+	return h
+}
+
+// HandleFunc 把fn包装成typedHandler并注册到code上
+func HandleFunc(env Env, code MessageCode, fn interface{}) {
+	env.AddHandler(code, NewTypedHandler(fn))
+}
+
+func (h *typedHandler) OnMessage(ctx Context, message *RawMessage) error {
+	payload := reflect.New(h.payloadType)
+	if len(message.RawData) != 0 && string(message.RawData) != "null" {
+		if err := ctx.Unmarshal(message.RawData, payload.Interface()); err != nil {
+			return NewCodeError(ErrCodeBadRequest, "invalid data")
+		}
+	}
+	if err := ValidateRequired(payload.Interface()); err != nil {
+		return err
+	}
+	arg := payload
+	if !h.isPointer {
+		arg = payload.Elem()
+	}
+	out := h.fn.Call([]reflect.Value{reflect.ValueOf(ctx), arg})
+	if err, _ := out[0].Interface().(error); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateRequired 校验结构体中带有 validate:"required" 标签的字段不为零值
+func ValidateRequired(v interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if field.Tag.Get("validate") != "required" {
+			continue
+		}
+		fieldValue := value.Field(i)
+		isZero := fieldValue.IsZero()
+		if fieldValue.Kind() == reflect.String {
+			isZero = strings.TrimSpace(fieldValue.String()) == ""
+		}
+		if isZero {
+			return CodeErrorf(ErrCodeBadRequest, "missing required field %s", field.Name)
+		}
+	}
+	return nil
+}
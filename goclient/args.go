@@ -0,0 +1,406 @@
+package goclient
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ArgType int8
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgBool
+	ArgDuration
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case ArgInt:
+		return "int"
+	case ArgBool:
+		return "bool"
+	case ArgDuration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+func (t ArgType) parse(s string) (interface{}, error) {
+	switch t {
+	case ArgInt:
+		return strconv.ParseInt(s, 10, 64)
+	case ArgBool:
+		return strconv.ParseBool(s)
+	case ArgDuration:
+		return time.ParseDuration(s)
+	default:
+		return s, nil
+	}
+}
+
+// Completion 交互输入时参数值的补全方式
+type Completion int8
+
+const (
+	CompleteNone Completion = iota
+	// CompleteCommand 补全命令名
+	CompleteCommand
+	// CompleteUser 补全在线用户的ID或昵称
+	CompleteUser
+	// CompleteFile 补全本地文件路径
+	CompleteFile
+)
+
+// Arg 位置参数，可选参数只能在必选参数之后，Variadic只能是最后一个参数
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+	// Variadic 收集剩余的所有参数，用String取时以空格连接
+	Variadic bool
+	Help     string
+	Complete Completion
+	// Secret 包含该参数的输入不保存到输入历史，例如密码
+	Secret bool
+}
+
+// Flag 命名参数，使用-name value或-name=value，ArgBool类型的flag可以只写-name
+type Flag struct {
+	Name    string
+	Type    ArgType
+	Default string
+	Help    string
+}
+
+// Args 校验并转换后的命令参数
+type Args struct {
+	values map[string]interface{}
+	lists  map[string][]string
+	set    map[string]bool
+}
+
+func (a *Args) String(name string) string {
+	if list, ok := a.lists[name]; ok {
+		return strings.Join(list, " ")
+	}
+	s, _ := a.values[name].(string)
+	return s
+}
+
+// Strings 返回Variadic参数的每一项
+func (a *Args) Strings(name string) []string {
+	if list, ok := a.lists[name]; ok {
+		return list
+	}
+	if s, ok := a.values[name].(string); ok {
+		return []string{s}
+	}
+	return nil
+}
+
+func (a *Args) Int(name string) int64 {
+	i, _ := a.values[name].(int64)
+	return i
+}
+
+func (a *Args) Bool(name string) bool {
+	b, _ := a.values[name].(bool)
+	return b
+}
+
+func (a *Args) Duration(name string) time.Duration {
+	d, _ := a.values[name].(time.Duration)
+	return d
+}
+
+// Has 参数是否由用户显式给出
+func (a *Args) Has(name string) bool {
+	return a.set[name]
+}
+
+// UsageError 参数不合法
+type UsageError struct {
+	Command *Command
+	Err     error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error() + ", usage: " + e.Command.Usage()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// Tokenize 按空白切分，支持单引号、双引号和反斜杠转义，单引号内不处理转义
+func Tokenize(line string) ([]string, error) {
+	var tokens []string
+	sb := &strings.Builder{}
+	inToken := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			if quote == '"' {
+				switch r {
+				case 'n':
+					r = '\n'
+				case 't':
+					r = '\t'
+				case '"', '\\':
+				default:
+					sb.WriteRune('\\')
+				}
+			}
+			sb.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inToken = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				sb.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inToken = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inToken {
+				tokens = append(tokens, sb.String())
+				sb.Reset()
+				inToken = false
+			}
+		default:
+			sb.WriteRune(r)
+			inToken = true
+		}
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inToken {
+		tokens = append(tokens, sb.String())
+	}
+	return tokens, nil
+}
+
+// Validate 检查参数声明是否合法
+func (c *Command) Validate() error {
+	names := make(map[string]bool)
+	optional := false
+	for i, arg := range c.Args {
+		if len(arg.Name) == 0 || names[arg.Name] {
+			return fmt.Errorf("invalid or duplicate arg name %q", arg.Name)
+		}
+		names[arg.Name] = true
+		if arg.Variadic && i != len(c.Args)-1 {
+			return fmt.Errorf("variadic arg %s must be the last one", arg.Name)
+		}
+		if arg.Variadic && arg.Type != ArgString {
+			return fmt.Errorf("variadic arg %s must be a string", arg.Name)
+		}
+		if optional && !arg.Optional {
+			return fmt.Errorf("required arg %s after optional arg", arg.Name)
+		}
+		optional = optional || arg.Optional
+	}
+	for _, flag := range c.Flags {
+		if len(flag.Name) == 0 || names[flag.Name] {
+			return fmt.Errorf("invalid or duplicate flag name %q", flag.Name)
+		}
+		names[flag.Name] = true
+		if len(flag.Default) != 0 {
+			if _, err := flag.Type.parse(flag.Default); err != nil {
+				return fmt.Errorf("invalid default of flag %s: %w", flag.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Command) flag(name string) (*Flag, bool) {
+	for i := range c.Flags {
+		if c.Flags[i].Name == name {
+			return &c.Flags[i], true
+		}
+	}
+	return nil, false
+}
+
+// Parse 按声明解析参数，"--"之后的参数都当作位置参数
+func (c *Command) Parse(tokens []string) (*Args, error) {
+	args := &Args{
+		values: make(map[string]interface{}),
+		lists:  make(map[string][]string),
+		set:    make(map[string]bool),
+	}
+	usageError := func(format string, a ...interface{}) error {
+		return &UsageError{Command: c, Err: fmt.Errorf(format, a...)}
+	}
+	var positional []string
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token == "--" {
+			positional = append(positional, tokens[i+1:]...)
+			break
+		}
+		if len(token) < 2 || token[0] != '-' {
+			positional = append(positional, token)
+			continue
+		}
+		name, value, hasValue := strings.TrimLeft(token, "-"), "", false
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		flag, ok := c.flag(name)
+		if !ok {
+			// 负数当作位置参数
+			if _, err := strconv.ParseFloat(token, 64); err == nil {
+				positional = append(positional, token)
+				continue
+			}
+			return nil, usageError("unknown flag -%s", name)
+		}
+		if !hasValue {
+			if flag.Type == ArgBool {
+				value = "true"
+			} else if i+1 < len(tokens) {
+				i++
+				value = tokens[i]
+			} else {
+				return nil, usageError("flag -%s requires a %s value", name, flag.Type)
+			}
+		}
+		parsed, err := flag.Type.parse(value)
+		if err != nil {
+			return nil, usageError("invalid %s value %q for flag -%s", flag.Type, value, name)
+		}
+		args.values[name] = parsed
+		args.set[name] = true
+	}
+	for _, flag := range c.Flags {
+		if args.set[flag.Name] {
+			continue
+		}
+		value := flag.Default
+		if len(value) == 0 && flag.Type != ArgString {
+			continue
+		}
+		parsed, _ := flag.Type.parse(value)
+		args.values[flag.Name] = parsed
+	}
+	for i, arg := range c.Args {
+		if arg.Variadic {
+			rest := positional[min(i, len(positional)):]
+			if len(rest) == 0 && !arg.Optional {
+				return nil, usageError("missing argument <%s>", arg.Name)
+			}
+			args.lists[arg.Name] = append([]string(nil), rest...)
+			args.set[arg.Name] = len(rest) != 0
+			positional = positional[:min(i, len(positional))]
+			break
+		}
+		if i >= len(positional) || len(positional[i]) == 0 {
+			if !arg.Optional {
+				return nil, usageError("missing argument <%s>", arg.Name)
+			}
+			if arg.Type == ArgString {
+				args.values[arg.Name] = ""
+			}
+			continue
+		}
+		parsed, err := arg.Type.parse(positional[i])
+		if err != nil {
+			return nil, usageError("invalid %s value %q for <%s>", arg.Type, positional[i], arg.Name)
+		}
+		args.values[arg.Name] = parsed
+		args.set[arg.Name] = true
+	}
+	if len(c.Args) == 0 || !c.Args[len(c.Args)-1].Variadic {
+		if len(positional) > len(c.Args) {
+			return nil, usageError("too many arguments")
+		}
+	}
+	return args, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Usage 根据参数声明生成用法，例如 sendfile [-force] <remoteID> <path>
+func (c *Command) Usage() string {
+	sb := &strings.Builder{}
+	sb.WriteString(c.Command)
+	for _, flag := range c.Flags {
+		if flag.Type == ArgBool {
+			sb.WriteString(" [-" + flag.Name + "]")
+		} else {
+			sb.WriteString(" [-" + flag.Name + " " + flag.Type.String() + "]")
+		}
+	}
+	for _, arg := range c.Args {
+		name := arg.Name
+		if arg.Variadic {
+			name += "..."
+		}
+		if arg.Optional {
+			sb.WriteString(" [" + name + "]")
+		} else {
+			sb.WriteString(" <" + name + ">")
+		}
+	}
+	return sb.String()
+}
+
+// HasSecret 是否有Secret参数
+func (c *Command) HasSecret() bool {
+	for _, arg := range c.Args {
+		if arg.Secret {
+			return true
+		}
+	}
+	return false
+}
+
+// Help 用法、说明以及每个参数的帮助
+func (c *Command) Help() string {
+	sb := &strings.Builder{}
+	sb.WriteString("usage: " + c.Usage())
+	if len(c.Alias) != 0 {
+		sb.WriteString("\nalias: " + strings.Join(c.Alias, ", "))
+	}
+	if len(c.Tips) != 0 {
+		sb.WriteString("\n" + c.Tips)
+	}
+	for _, arg := range c.Args {
+		sb.WriteString(fmt.Sprintf("\n  <%s> %s", arg.Name, arg.Type))
+		if len(arg.Help) != 0 {
+			sb.WriteString("  " + arg.Help)
+		}
+	}
+	for _, flag := range c.Flags {
+		sb.WriteString(fmt.Sprintf("\n  -%s %s", flag.Name, flag.Type))
+		if len(flag.Help) != 0 {
+			sb.WriteString("  " + flag.Help)
+		}
+		if len(flag.Default) != 0 {
+			sb.WriteString(" (default " + flag.Default + ")")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,164 @@
+package goclient
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "   ", want: nil},
+		{line: "send hello world", want: []string{"send", "hello", "world"}},
+		{line: "  send\thello \n", want: []string{"send", "hello"}},
+		{line: `send "hello  world"`, want: []string{"send", "hello  world"}},
+		{line: `send 'hello  world'`, want: []string{"send", "hello  world"}},
+		{line: `send ""`, want: []string{"send", ""}},
+		{line: `send a"b c"d`, want: []string{"send", "ab cd"}},
+		{line: `send hello\ world`, want: []string{"send", "hello world"}},
+		{line: `send "a\"b"`, want: []string{"send", `a"b`}},
+		{line: `send "a\nb\tc"`, want: []string{"send", "a\nb\tc"}},
+		{line: `send "a\qb"`, want: []string{"send", `a\qb`}},
+		{line: `send 'a\nb'`, want: []string{"send", `a\nb`}},
+		{line: `send "it's"`, want: []string{"send", "it's"}},
+		{line: `send \\`, want: []string{"send", `\`}},
+		{line: `send \`, wantErr: true},
+		{line: `send "hello`, wantErr: true},
+		{line: `send 'hello`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Tokenize(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Tokenize(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestCommandParse(t *testing.T) {
+	command := &Command{
+		Command: "test",
+		Args: []Arg{
+			{Name: "name"},
+			{Name: "count", Type: ArgInt, Optional: true},
+			{Name: "rest", Optional: true, Variadic: true},
+		},
+		Flags: []Flag{
+			{Name: "force", Type: ArgBool},
+			{Name: "room", Default: "lobby"},
+			{Name: "wait", Type: ArgDuration},
+			{Name: "n", Type: ArgInt},
+		},
+	}
+	if err := command.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		tokens  []string
+		check   func(t *testing.T, args *Args)
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			tokens: []string{"alice"},
+			check: func(t *testing.T, args *Args) {
+				if args.String("name") != "alice" || args.Int("count") != 0 || args.Bool("force") {
+					t.Errorf("unexpected args %+v", args.values)
+				}
+				if args.String("room") != "lobby" || args.Has("room") || args.Has("count") || args.Has("rest") {
+					t.Errorf("flag default or set state is wrong: %+v %+v", args.values, args.set)
+				}
+			},
+		},
+		{
+			name:   "flags in any position",
+			tokens: []string{"-force", "alice", "--room=dev", "3", "-wait", "2s"},
+			check: func(t *testing.T, args *Args) {
+				if !args.Bool("force") || args.String("room") != "dev" || args.Duration("wait") != 2*time.Second {
+					t.Errorf("unexpected flags %+v", args.values)
+				}
+				if args.Int("count") != 3 || !args.Has("count") {
+					t.Errorf("count = %d, want 3", args.Int("count"))
+				}
+			},
+		},
+		{
+			name:   "variadic",
+			tokens: []string{"alice", "1", "hello", "big", "world"},
+			check: func(t *testing.T, args *Args) {
+				if got := args.Strings("rest"); !reflect.DeepEqual(got, []string{"hello", "big", "world"}) {
+					t.Errorf("rest = %q", got)
+				}
+				if args.String("rest") != "hello big world" {
+					t.Errorf("String(rest) = %q", args.String("rest"))
+				}
+			},
+		},
+		{
+			name:   "double dash stops flags",
+			tokens: []string{"alice", "1", "--", "-force", "-x"},
+			check: func(t *testing.T, args *Args) {
+				if args.Bool("force") {
+					t.Error("-force after -- must not be a flag")
+				}
+				if got := args.Strings("rest"); !reflect.DeepEqual(got, []string{"-force", "-x"}) {
+					t.Errorf("rest = %q", got)
+				}
+			},
+		},
+		{
+			name:   "negative number is positional",
+			tokens: []string{"alice", "-5"},
+			check: func(t *testing.T, args *Args) {
+				if args.Int("count") != -5 {
+					t.Errorf("count = %d, want -5", args.Int("count"))
+				}
+			},
+		},
+		{
+			name:   "negative flag value",
+			tokens: []string{"alice", "-n", "-2"},
+			check: func(t *testing.T, args *Args) {
+				if args.Int("n") != -2 {
+					t.Errorf("n = %d, want -2", args.Int("n"))
+				}
+			},
+		},
+		{name: "missing required", tokens: nil, wantErr: true},
+		{name: "unknown flag", tokens: []string{"alice", "-unknown"}, wantErr: true},
+		{name: "flag without value", tokens: []string{"alice", "-room"}, wantErr: true},
+		{name: "invalid int", tokens: []string{"alice", "many"}, wantErr: true},
+		{name: "invalid flag value", tokens: []string{"alice", "-wait=soon"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := command.Parse(tt.tokens)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.tokens, err, tt.wantErr)
+			}
+			if err != nil {
+				if _, ok := err.(*UsageError); !ok {
+					t.Errorf("Parse(%q) error type = %T, want *UsageError", tt.tokens, err)
+				}
+				return
+			}
+			tt.check(t, args)
+		})
+	}
+}
+
+func TestCommandParseTooManyArguments(t *testing.T) {
+	command := &Command{Command: "test", Args: []Arg{{Name: "name"}}}
+	if _, err := command.Parse([]string{"alice", "bob"}); err == nil {
+		t.Error("Parse() with extra arguments should fail")
+	}
+}
@@ -0,0 +1,109 @@
+package goclient
+
+import (
+	"fmt"
+	"gochat/common"
+	"time"
+)
+
+func parseArgType(s string) (ArgType, error) {
+	switch s {
+	case "", "string":
+		return ArgString, nil
+	case "int":
+		return ArgInt, nil
+	case "bool":
+		return ArgBool, nil
+	case "duration":
+		return ArgDuration, nil
+	default:
+		return 0, fmt.Errorf("unknown arg type %q", s)
+	}
+}
+
+func parseCompletion(s string) Completion {
+	switch s {
+	case "command":
+		return CompleteCommand
+	case "user":
+		return CompleteUser
+	case "file":
+		return CompleteFile
+	default:
+		return CompleteNone
+	}
+}
+
+// CommandFromSpec 把服务端公布的命令转换为本地命令，参数按声明校验后组装成消息体，
+// duration类型的值以字符串形式发送
+func CommandFromSpec(spec common.CommandSpec) (*Command, error) {
+	command := &Command{
+		Command:      spec.Name,
+		Alias:        append([]string(nil), spec.Alias...),
+		UseParseFunc: true,
+		Tips:         spec.Help,
+	}
+	for _, a := range spec.Args {
+		argType, err := parseArgType(a.Type)
+		if err != nil {
+			return nil, fmt.Errorf("arg %s of command %s: %w", a.Name, spec.Name, err)
+		}
+		command.Args = append(command.Args, Arg{
+			Name:     a.Name,
+			Type:     argType,
+			Optional: a.Optional,
+			Variadic: a.Variadic,
+			Help:     a.Help,
+			Complete: parseCompletion(a.Complete),
+			Secret:   a.Secret,
+		})
+	}
+	for _, f := range spec.Flags {
+		flagType, err := parseArgType(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("flag %s of command %s: %w", f.Name, spec.Name, err)
+		}
+		command.Flags = append(command.Flags, Flag{Name: f.Name, Type: flagType, Default: f.Default, Help: f.Help})
+	}
+	if err := command.Validate(); err != nil {
+		return nil, fmt.Errorf("command %s: %w", spec.Name, err)
+	}
+	command.ParseFunc = func(args *Args) (*common.Message, error) {
+		return &common.Message{Code: spec.Code, RawData: specPayload(spec, args)}, nil
+	}
+	return command, nil
+}
+
+func specPayload(spec common.CommandSpec, args *Args) interface{} {
+	if len(spec.Payload) != 0 {
+		value, _ := args.value(spec.Payload)
+		return value
+	}
+	if len(spec.Args) == 0 && len(spec.Flags) == 0 {
+		return nil
+	}
+	payload := make(map[string]interface{})
+	for _, a := range spec.Args {
+		if value, ok := args.value(a.Name); ok {
+			payload[a.Name] = value
+		}
+	}
+	for _, f := range spec.Flags {
+		if value, ok := args.value(f.Name); ok {
+			payload[f.Name] = value
+		}
+	}
+	return payload
+}
+
+// value 返回适合编码为JSON的参数值，Variadic参数以空格连接
+func (a *Args) value(name string) (interface{}, bool) {
+	if _, ok := a.lists[name]; ok {
+		return a.String(name), true
+	}
+	value, ok := a.values[name]
+	if d, isDuration := value.(time.Duration); isDuration {
+		return d.String(), ok
+	}
+	return value, ok
+}
@@ -1,11 +1,13 @@
 package goclient
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"gochat/common"
-	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +16,21 @@ type ClientContext struct {
 	localAddr  string
 	client     *Client
 	common.Channel
+	values  sync.Map
+	logLock sync.Mutex
+	logger  common.Logger
+}
+
+func (ctx *ClientContext) Logger() common.Logger {
+	ctx.logLock.Lock()
+	defer ctx.logLock.Unlock()
+	return ctx.logger
+}
+
+func (ctx *ClientContext) AddLogFields(fields ...common.Field) {
+	ctx.logLock.Lock()
+	defer ctx.logLock.Unlock()
+	ctx.logger = ctx.logger.With(fields...)
 }
 
 func (ctx *ClientContext) RemoteAddr() string {
@@ -24,6 +41,19 @@ func (ctx *ClientContext) LocalAddr() string {
 	return ctx.localAddr
 }
 
+func (ctx *ClientContext) Context() context.Context {
+	return ctx.client.ctx
+}
+
+func (ctx *ClientContext) Value(key interface{}) interface{} {
+	value, _ := ctx.values.Load(key)
+	return value
+}
+
+func (ctx *ClientContext) SetValue(key, value interface{}) {
+	ctx.values.Store(key, value)
+}
+
 func (ctx *ClientContext) AddHandler(code common.MessageCode, handler common.Handler) {
 	ctx.client.AddHandler(code, handler)
 }
@@ -42,28 +72,72 @@ type Client struct {
 	messageQueue chan *common.Message
 	dispatcher   Dispatcher
 	lock         *sync.Mutex
+	requestID    int64
+	modules      *common.ModuleManager
+	ctx          context.Context
+	cancel       context.CancelFunc
+	observers    []Observer
+	queued       int64
+	written      int64
+}
+
+// Observer 在handler之前收到每条来自服务端的消息，不能修改消息
+type Observer func(message *common.RawMessage)
+
+type Config struct {
+	Address string
+	// Logger 为nil时使用Info级别的控制台文本日志
+	Logger common.Logger
+	// HandshakeTimeout 等待服务端握手确认的最长时间，<=0时使用common.DefaultHandshakeTimeout
+	HandshakeTimeout time.Duration
+	// Token 握手时发送给服务端的认证令牌，可以为空
+	Token string
+	// TLSConfig 非nil时通过TLS连接，ServerName为空时使用Address中的主机名
+	TLSConfig *tls.Config
 }
 
 func NewClient(address string) (*Client, error) {
-	conn, err := net.Dial("tcp", address)
+	return NewClientWithConfig(Config{Address: address})
+}
+
+func NewClientWithConfig(config Config) (*Client, error) {
+	if err := common.CheckCodes(); err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("tcp", config.Address)
 	if err != nil {
 		return nil, err
 	}
+	if config.TLSConfig != nil {
+		tlsConfig := config.TLSConfig
+		if len(tlsConfig.ServerName) == 0 {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(config.Address)
+		}
+		conn = tls.Client(conn, tlsConfig)
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = common.NewConsoleLogger(common.Info)
+	}
 	client := &Client{
 		conn:         conn,
 		handlerMap:   make(map[common.MessageCode]common.Handler),
 		codec:        common.NewJsonCodec(conn),
-		logger:       common.NewConsoleLogger(common.Debug),
+		logger:       logger,
 		once:         &sync.Once{},
 		messageQueue: make(chan *common.Message, 1000),
 		lock:         &sync.Mutex{},
 	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	client.modules = common.NewModuleManager(client)
 	header := common.NewHeader(common.JsonCodecType)
-	_, err = client.conn.Write(header.Bytes())
-	if err != nil {
+	header.Token = config.Token
+	if err = common.ClientHandshake(conn, header, config.HandshakeTimeout); err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
-	client.logger.Info(fmt.Sprintf("start client success, local address=%s", conn.LocalAddr().String()))
+	client.logger.Info("start client success", common.F("local", conn.LocalAddr().String()))
 	return client, nil
 }
 
@@ -71,7 +145,7 @@ func (c *Client) AddHandler(code common.MessageCode, handler common.Handler) {
 	c.lock.Lock()
 	_, ok := c.handlerMap[code]
 	if ok {
-		c.logger.Fatal("duplicate handler")
+		c.logger.Fatal("duplicate handler", common.F("code", code))
 	}
 	c.handlerMap[code] = handler
 	c.lock.Unlock()
@@ -82,12 +156,45 @@ func (c *Client) Close() error {
 	c.isClosed = true
 	var err error
 	c.once.Do(func() {
+		c.cancel()
 		err = c.conn.Close()
-		close(c.messageQueue)
+		for _, e := range c.modules.Stop() {
+			c.logger.Error("stop module error", common.Err(e))
+		}
 	})
 	return err
 }
 
+// AddModule 添加模块，模块在Start时按依赖顺序启动，实现了CommandProvider的模块会同时注册其命令
+func (c *Client) AddModule(module common.Module) error {
+	return c.modules.Add(module)
+}
+
+// SetModuleEnabled 在Start之前调用，停用的模块及其handler、命令不会被加载
+func (c *Client) SetModuleEnabled(name string, enabled bool) {
+	c.modules.SetEnabled(name, enabled)
+}
+
+func (c *Client) startModules() error {
+	modules, err := c.modules.Start()
+	if err != nil {
+		return err
+	}
+	for _, module := range modules {
+		c.logger.Debug("module started", common.F("module", module.Name()))
+		provider, ok := module.(CommandProvider)
+		if !ok {
+			continue
+		}
+		for _, command := range provider.Commands() {
+			if err := c.Register(command); err != nil {
+				return fmt.Errorf("register command %s of module %s error: %w", command.Command, module.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
 func (c *Client) SetDispatcher(dispatcher Dispatcher) {
 	c.dispatcher = dispatcher
 }
@@ -103,40 +210,49 @@ func (c *Client) RemoveHandler(code common.MessageCode) {
 	c.lock.Lock()
 	handler, ok := c.handlerMap[code]
 	if !ok {
-		c.logger.Error(fmt.Sprintf("not found handler code=%d", code))
+		c.logger.Error("not found handler", common.F("code", code))
 		c.lock.Unlock()
 		return
 	}
-	c.logger.Info(fmt.Sprintf("remove handler code=%d", code))
+	c.logger.Debug("remove handler", common.F("code", code))
 	delete(c.handlerMap, code)
 	c.lock.Unlock()
 	handler.OnRemove(c)
 }
 
 func (c *Client) Start() {
+	if err := c.startModules(); err != nil {
+		c.logger.Fatal("start modules error", common.Err(err))
+	}
 	ctx := &ClientContext{
 		remoteAddr: c.conn.RemoteAddr().String(),
 		localAddr:  c.conn.LocalAddr().String(),
+		client:     c,
 		Channel:    common.NewSimpleChannel(c.codec, c.conn),
+		logger:     c.logger.With(common.F("remote", c.conn.RemoteAddr().String())),
 	}
 	for _, handler := range c.handlerMap {
 		handler.OnActive(ctx)
 	}
 	go c.dispatcher.Dispatch()
 	go func() {
-		c.logger.Info("start pull message")
-		for msg := range c.messageQueue {
-			if c.isClosed {
+		c.logger.Debug("start pull message")
+		for {
+			var msg *common.Message
+			select {
+			case <-c.ctx.Done():
+				c.logger.Debug("client is closed, end pull message")
 				return
+			case msg = <-c.messageQueue:
 			}
 			if msg == nil {
 				continue
 			}
 			if err := ctx.Write(msg); err != nil {
-				log.Println(err)
+				ctx.Logger().Error("write message error", common.F("code", msg.Code), common.Err(err))
 			}
+			atomic.AddInt64(&c.written, 1)
 		}
-		c.logger.Info("client is closed, end pull message")
 	}()
 	for {
 		if c.isClosed {
@@ -146,29 +262,86 @@ func (c *Client) Start() {
 		if err != nil {
 			// 非主动关闭
 			if !c.isClosed {
-				c.logger.Error(err)
+				ctx.Logger().Error("read message error", common.Err(err))
 			}
 			break
 		}
+		c.notifyObservers(message)
 		handler, ok := c.handlerMap[message.Code]
 		if !ok {
-			log.Println("unknown message", message)
+			ctx.Logger().Info("unknown message", common.F("code", message.Code), common.F("data", string(message.RawData)))
 			continue
 		}
 		if err := handler.OnMessage(ctx, message); err != nil {
-			log.Println(err)
+			ctx.Logger().Error("handle message error", common.F("code", message.Code), common.Err(err))
 			continue
 		}
 	}
-	log.Println("client is closing")
+	c.logger.Info("client is closing")
 	_ = c.Close()
 	for _, handler := range c.handlerMap {
 		handler.OnClose(ctx)
 	}
-	log.Println("closing success")
+	c.logger.Info("closing success")
 	time.Sleep(time.Second * 3)
 }
 
-func (c *Client) SendMessage(message *common.Message) {
-	c.messageQueue <- message
+// Logger 返回客户端的根logger
+func (c *Client) Logger() common.Logger {
+	return c.logger
+}
+
+// SendMessage 发送消息，未指定RequestID时自动分配，服务端的错误回复会带上该ID，客户端关闭后返回0
+func (c *Client) SendMessage(message *common.Message) int64 {
+	if message == nil {
+		return 0
+	}
+	if message.RequestID == 0 {
+		message.RequestID = atomic.AddInt64(&c.requestID, 1)
+	}
+	select {
+	case <-c.ctx.Done():
+		return 0
+	case c.messageQueue <- message:
+		atomic.AddInt64(&c.queued, 1)
+		return message.RequestID
+	}
+}
+
+// Flush 等待已提交的消息全部写出，超时或客户端关闭时返回false
+func (c *Client) Flush(timeout time.Duration) bool {
+	target := atomic.LoadInt64(&c.queued)
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&c.written) < target {
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	return true
+}
+
+// AddObserver 在Start之前调用
+func (c *Client) AddObserver(observer Observer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.observers = append(c.observers, observer)
+}
+
+func (c *Client) notifyObservers(message *common.RawMessage) {
+	c.lock.Lock()
+	observers := c.observers
+	c.lock.Unlock()
+	for _, observer := range observers {
+		observer(message)
+	}
+}
+
+// Done 客户端关闭后关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.ctx.Done()
 }
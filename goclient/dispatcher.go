@@ -2,12 +2,15 @@ package goclient
 
 import "gochat/common"
 
+// Command 客户端命令，参数由dispatcher按Args和Flags的声明解析校验后传入
 type Command struct {
 	Command        string
 	Alias          []string
-	ParseFunc      func(params string) (*common.Message, error)
+	Args           []Arg
+	Flags          []Flag
+	ParseFunc      func(args *Args) (*common.Message, error)
 	UseParseFunc   bool
-	LocalParseFunc func(params string) error
+	LocalParseFunc func(args *Args) error
 	Tips           string
 }
 
@@ -15,3 +18,9 @@ type Dispatcher interface {
 	Dispatch()
 	Register(command *Command) error
 }
+
+// CommandProvider 模块实现该接口后，其本地命令会随模块一起注册。
+// 本地命令带有客户端的解析和执行逻辑，所以不放在common.Module的CommandSpecs中
+type CommandProvider interface {
+	Commands() []*Command
+}
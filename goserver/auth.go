@@ -0,0 +1,164 @@
+package goserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"gochat/common"
+	"net"
+)
+
+// HandshakeInfo 握手时可用于认证的信息
+type HandshakeInfo struct {
+	RemoteAddr string
+	// Token 客户端握手头中的令牌
+	Token string
+	// TLS 使用TLS时为握手完成后的连接状态，否则为nil
+	TLS *tls.ConnectionState
+}
+
+// Authenticator 认证连接并把身份附加到连接上。
+// 连接认证前，注册时标记了RequireAuth的消息会被服务端直接拒绝
+type Authenticator interface {
+	// Handshake 握手时调用，返回nil的身份表示留给后续消息认证，返回错误时拒绝握手
+	Handshake(info *HandshakeInfo) (*common.Principal, error)
+	// Message 连接未认证时每条消息分发前调用，返回nil的身份表示该消息与认证无关，
+	// 返回错误时拒绝该消息。认证成功后消息照常交给handler处理
+	Message(ctx common.Context, message *common.RawMessage) (*common.Principal, error)
+}
+
+// TokenAuthenticator 用握手令牌认证，Verify返回nil的身份表示令牌为空时不认证
+type TokenAuthenticator struct {
+	Verify func(token string) (*common.Principal, error)
+}
+
+func (a *TokenAuthenticator) Handshake(info *HandshakeInfo) (*common.Principal, error) {
+	return a.Verify(info.Token)
+}
+
+func (a *TokenAuthenticator) Message(common.Context, *common.RawMessage) (*common.Principal, error) {
+	return nil, nil
+}
+
+// CertificateAuthenticator 用已验证的客户端证书认证，身份名为证书的CommonName。
+// 证书链由tls.Config的ClientCAs和ClientAuth负责验证
+type CertificateAuthenticator struct{}
+
+func (CertificateAuthenticator) Handshake(info *HandshakeInfo) (*common.Principal, error) {
+	if info.TLS == nil || len(info.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	cert := info.TLS.VerifiedChains[0][0]
+	if len(cert.Subject.CommonName) == 0 {
+		return nil, errors.New("client certificate has no common name")
+	}
+	return &common.Principal{Name: cert.Subject.CommonName, Method: "certificate"}, nil
+}
+
+func (CertificateAuthenticator) Message(common.Context, *common.RawMessage) (*common.Principal, error) {
+	return nil, nil
+}
+
+// MessageAuthenticator 用指定消息码的消息认证，例如用户名密码登录
+type MessageAuthenticator struct {
+	Code         common.MessageCode
+	Authenticate func(ctx common.Context, message *common.RawMessage) (*common.Principal, error)
+}
+
+func (a *MessageAuthenticator) Handshake(*HandshakeInfo) (*common.Principal, error) {
+	return nil, nil
+}
+
+func (a *MessageAuthenticator) Message(ctx common.Context, message *common.RawMessage) (*common.Principal, error) {
+	if message.Code != a.Code {
+		return nil, nil
+	}
+	return a.Authenticate(ctx, message)
+}
+
+// Authenticators 按顺序尝试，使用第一个返回身份或错误的结果
+type Authenticators []Authenticator
+
+func (a Authenticators) Handshake(info *HandshakeInfo) (*common.Principal, error) {
+	for _, authenticator := range a {
+		if principal, err := authenticator.Handshake(info); principal != nil || err != nil {
+			return principal, err
+		}
+	}
+	return nil, nil
+}
+
+func (a Authenticators) Message(ctx common.Context, message *common.RawMessage) (*common.Principal, error) {
+	for _, authenticator := range a {
+		if principal, err := authenticator.Message(ctx, message); principal != nil || err != nil {
+			return principal, err
+		}
+	}
+	return nil, nil
+}
+
+// Authorizer 在认证之后、消息交给handler之前检查权限，principal为nil表示连接未认证，
+// 返回错误时拒绝该消息
+type Authorizer interface {
+	Authorize(ctx common.Context, principal *common.Principal, message *common.RawMessage) error
+}
+
+// authenticateHandshake TLS连接在读取握手头时已完成TLS握手，可以取到客户端证书
+func (s *Server) authenticateHandshake(conn net.Conn, header *common.Header) (*common.Principal, error) {
+	if s.config.Authenticator == nil {
+		return nil, nil
+	}
+	info := &HandshakeInfo{RemoteAddr: conn.RemoteAddr().String(), Token: header.Token}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		info.TLS = &state
+	}
+	principal, err := s.config.Authenticator.Handshake(info)
+	if err != nil {
+		s.metrics.authFailures.WithLabelValues("handshake").Inc()
+	}
+	return principal, err
+}
+
+// authenticate 连接未认证时先交给Authenticator，仍未认证且消息需要认证时返回错误
+func (s *Server) authenticate(ctx *ServerContext, message *common.RawMessage) error {
+	if ctx.Principal() != nil {
+		return nil
+	}
+	if s.config.Authenticator != nil {
+		principal, err := s.config.Authenticator.Message(&messageContext{ServerContext: ctx, ctx: ctx.ctx, message: message}, message)
+		if err != nil {
+			s.metrics.authFailures.WithLabelValues("message").Inc()
+			if _, ok := common.AsCodeError(err); ok {
+				return err
+			}
+			ctx.Logger().Info("authentication failed", common.F("code", message.Code), common.Err(err))
+			return common.NewCodeError(common.ErrCodeUnauthorized, "authentication failed")
+		}
+		if principal != nil {
+			ctx.SetPrincipal(principal)
+			ctx.Logger().Info("authenticated", common.F("principal", principal.Name), common.F("method", principal.Method))
+			return nil
+		}
+	}
+	if info, ok := common.LookupCode(message.Code); ok && info.RequireAuth {
+		return common.ErrUnauthenticated
+	}
+	return nil
+}
+
+func (s *Server) authorize(ctx *ServerContext, message *common.RawMessage) error {
+	if s.config.Authorizer == nil {
+		return nil
+	}
+	principal := ctx.Principal()
+	err := s.config.Authorizer.Authorize(&messageContext{ServerContext: ctx, ctx: ctx.ctx, message: message}, principal, message)
+	if err == nil {
+		return nil
+	}
+	s.metrics.authFailures.WithLabelValues("authorize").Inc()
+	if _, ok := common.AsCodeError(err); ok {
+		return err
+	}
+	ctx.Logger().Info("authorization failed", common.F("code", message.Code), common.Err(err))
+	return common.ErrPermissionDenied
+}
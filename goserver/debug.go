@@ -0,0 +1,106 @@
+package goserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// AddReadinessCheck 添加就绪检查，任一检查返回错误时/readyz返回503
+func (s *Server) AddReadinessCheck(name string, check func() error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.readyChecks[name] = check
+}
+
+// Ready 服务开始接受连接、未关闭且连接数未达到上限时返回nil
+func (s *Server) Ready() error {
+	if atomic.LoadInt32(&s.serving) == 0 {
+		return errors.New("not serving")
+	}
+	s.lock.Lock()
+	closed := s.isClosed
+	checks := make(map[string]func() error, len(s.readyChecks))
+	for name, check := range s.readyChecks {
+		checks[name] = check
+	}
+	s.lock.Unlock()
+	if closed {
+		return errors.New("shutting down")
+	}
+	if s.config.MaxConnections > 0 && s.OpenConnections() >= int64(s.config.MaxConnections) {
+		return fmt.Errorf("connection limit reached (%d)", s.config.MaxConnections)
+	}
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := checks[name](); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+type connectionDump struct {
+	Goroutines  int              `json:"goroutines"`
+	Open        int64            `json:"open"`
+	Connections []ConnectionInfo `json:"connections"`
+}
+
+// DebugHandler 提供健康检查、就绪检查、pprof以及goroutine和连接的dump
+//
+//	/healthz             进程存活即返回200
+//	/readyz              就绪时返回200，否则返回503及原因
+//	/debug/pprof/        net/http/pprof
+//	/debug/goroutines    所有goroutine的堆栈，?debug=1按标签聚合
+//	/debug/connections   当前连接列表
+func (s *Server) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := s.Ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("not ready: " + err.Error() + "\n"))
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		debug, err := strconv.Atoi(r.URL.Query().Get("debug"))
+		if err != nil {
+			debug = 2
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = runtimepprof.Lookup("goroutine").WriteTo(w, debug)
+	})
+	mux.HandleFunc("/debug/connections", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(connectionDump{
+			Goroutines:  runtime.NumGoroutine(),
+			Open:        s.OpenConnections(),
+			Connections: s.Connections(),
+		})
+	})
+	return mux
+}
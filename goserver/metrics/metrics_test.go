@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Total requests.", "code", "method")
+	requests.WithLabelValues("b", "get").Inc()
+	requests.WithLabelValues("a", "get").Add(2.5)
+	requests.WithLabelValues(`quo"te`, "new\nline").Inc()
+	r.NewCounter("bytes_total", "Total bytes.").Add(1024)
+	gauge := r.NewGauge("in_flight", "In flight.")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	r.NewGaugeFunc("users", "Online users.", func() float64 { return 7 })
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "code")
+	latency.WithLabelValues("a").Observe(0.05)
+	latency.WithLabelValues("a").Observe(0.5)
+	latency.WithLabelValues("a").Observe(3)
+
+	want := `# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{code="a",method="get"} 2.5
+requests_total{code="b",method="get"} 1
+requests_total{code="quo\"te",method="new\nline"} 1
+# HELP bytes_total Total bytes.
+# TYPE bytes_total counter
+bytes_total 1024
+# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP users Online users.
+# TYPE users gauge
+users 7
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{code="a",le="0.1"} 1
+latency_seconds_bucket{code="a",le="1"} 2
+latency_seconds_bucket{code="a",le="+Inf"} 3
+latency_seconds_sum{code="a"} 3.55
+latency_seconds_count{code="a"} 3
+`
+	buf := &bytes.Buffer{}
+	r.Write(buf)
+	if got := buf.String(); got != want {
+		t.Errorf("Write() output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("hits_total", "Hits.").Inc()
+	recorder := httptest.NewRecorder()
+	r.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(recorder.Body.String(), "\nhits_total 1\n") {
+		t.Errorf("body = %q", recorder.Body.String())
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup_total", "Dup.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate metric should panic")
+		}
+	}()
+	r.NewGauge("dup_total", "Dup.")
+}
+
+func TestVecLabelCount(t *testing.T) {
+	c := NewRegistry().NewCounterVec("labels_total", "Labels.", "code")
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong number of label values should panic")
+		}
+	}()
+	c.WithLabelValues("a", "b")
+}
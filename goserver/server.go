@@ -1,19 +1,59 @@
 package goserver
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"gochat/common"
+	"gochat/goserver/metrics"
+	"io"
 	"net"
+	"net/http"
 	"runtime/debug"
+	"runtime/pprof"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ServerContext struct {
+	id         int64
 	remoteAddr string
 	localAddr  string
 	env        common.Env
 	common.Channel
 	isClosed bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	values   sync.Map
+	logLock  sync.Mutex
+	logger   common.Logger
+	openedAt time.Time
+	// principal 连接认证后的身份，由authLock保护
+	authLock  sync.Mutex
+	principal *common.Principal
+}
+
+// ID 服务端为每个连接分配的唯一ID
+func (s *ServerContext) ID() int64 {
+	return s.id
+}
+
+func (s *ServerContext) OpenedAt() time.Time {
+	return s.openedAt
+}
+
+func (s *ServerContext) Logger() common.Logger {
+	s.logLock.Lock()
+	defer s.logLock.Unlock()
+	return s.logger
+}
+
+func (s *ServerContext) AddLogFields(fields ...common.Field) {
+	s.logLock.Lock()
+	defer s.logLock.Unlock()
+	s.logger = s.logger.With(fields...)
 }
 
 func (s *ServerContext) RemoteAddr() string {
@@ -24,6 +64,31 @@ func (s *ServerContext) LocalAddr() string {
 	return s.localAddr
 }
 
+func (s *ServerContext) Context() context.Context {
+	return s.ctx
+}
+
+func (s *ServerContext) Principal() *common.Principal {
+	s.authLock.Lock()
+	defer s.authLock.Unlock()
+	return s.principal
+}
+
+func (s *ServerContext) SetPrincipal(principal *common.Principal) {
+	s.authLock.Lock()
+	defer s.authLock.Unlock()
+	s.principal = principal
+}
+
+func (s *ServerContext) Value(key interface{}) interface{} {
+	value, _ := s.values.Load(key)
+	return value
+}
+
+func (s *ServerContext) SetValue(key, value interface{}) {
+	s.values.Store(key, value)
+}
+
 func (s *ServerContext) AddHandler(code common.MessageCode, handler common.Handler) {
 	s.env.AddHandler(code, handler)
 }
@@ -34,9 +99,34 @@ func (s *ServerContext) RemoveHandler(code common.MessageCode) {
 
 func (s *ServerContext) Close() error {
 	s.isClosed = true
+	s.cancel()
 	return s.Channel.Close()
 }
 
+// messageContext 单条消息分发期间使用，Context()返回消息级别的context
+type messageContext struct {
+	*ServerContext
+	ctx     context.Context
+	message *common.RawMessage
+}
+
+func (m *messageContext) Context() context.Context {
+	return m.ctx
+}
+
+func (m *messageContext) RequestID() int64 {
+	return m.message.RequestID
+}
+
+// Connection 返回连接级的ServerContext
+func (m *messageContext) Connection() common.Context {
+	return m.ServerContext
+}
+
+func (m *messageContext) Logger() common.Logger {
+	return m.ServerContext.Logger().With(common.F("code", m.message.Code), common.F("request", m.message.RequestID))
+}
+
 type Interceptor interface {
 	OnReadAfter(common.Context, *common.RawMessage) error
 	OnWriteBefore(common.Context, *common.Message)
@@ -45,6 +135,26 @@ type Interceptor interface {
 
 type Config struct {
 	Address string
+	// Logger 为nil时使用Info级别的控制台文本日志
+	Logger common.Logger
+	// HandshakeTimeout 等待客户端握手的最长时间，<=0时使用common.DefaultHandshakeTimeout
+	HandshakeTimeout time.Duration
+	// VerifyToken 校验握手中携带的令牌，为nil时不校验
+	VerifyToken func(token string) error
+	// Authenticator 为nil时连接不会被认证，标记了RequireAuth的消息只能在handler调用SetPrincipal后发送
+	Authenticator Authenticator
+	// Authorizer 为nil时认证后的连接可以发送任何消息
+	Authorizer Authorizer
+	// MaxConnections 同时打开的最大连接数，超过时直接关闭新连接，<=0表示不限制
+	MaxConnections int
+	// MetricsAddress 非空时在该地址的/metrics上以Prometheus文本格式输出指标
+	MetricsAddress string
+	// TLSConfig 非nil时在TLS之上提供服务
+	TLSConfig *tls.Config
+	// DebugAddress 非空时在该地址提供/healthz、/readyz、pprof以及goroutine和连接的dump
+	DebugAddress string
+	// ShutdownDelay Close时先让/readyz返回503，等待该时间让负载均衡摘除后再停止服务
+	ShutdownDelay time.Duration
 }
 
 type ChannelWrapper struct {
@@ -57,7 +167,13 @@ type ChannelWrapper struct {
 
 func (c *ChannelWrapper) Write(msg *common.Message) error {
 	c.OnWriteBefore(msg)
-	return c.Channel.Write(msg)
+	c.metrics.writesInFlight.Inc()
+	defer c.metrics.writesInFlight.Dec()
+	if err := c.Channel.Write(msg); err != nil {
+		return err
+	}
+	c.metrics.messagesOut.WithLabelValues(msg.Code.String()).Inc()
+	return nil
 }
 
 func (c *ChannelWrapper) Close() error {
@@ -82,8 +198,8 @@ func (c *ChannelWrapper) OnWriteBefore(msg *common.Message) {
 	var curInterceptor Interceptor
 	defer func() {
 		if err := recover(); err != nil {
-			c.Server.logger.Error(fmt.Sprintf("[panic] on write before error, interceptor name=%s, error=%s",
-				curInterceptor.Name(), err))
+			c.ServerContext.Logger().Error("[panic] on write before error",
+				common.F("interceptor", curInterceptor.Name()), common.F("panic", err))
 		}
 	}()
 	for _, interceptor := range c.interceptors {
@@ -96,8 +212,8 @@ func (c *ChannelWrapper) OnReadAfter(msg *common.RawMessage) (err error) {
 	var curInterceptor Interceptor
 	defer func() {
 		if e := recover(); e != nil {
-			c.Server.logger.Error(fmt.Sprintf("[panic] on read after error, interceptor name=%s, error=%s",
-				curInterceptor.Name(), e))
+			c.ServerContext.Logger().Error("[panic] on read after error",
+				common.F("interceptor", curInterceptor.Name()), common.F("panic", e))
 			err = fmt.Errorf("%s", e)
 		}
 	}()
@@ -118,29 +234,73 @@ type Server struct {
 	handlerMap   map[common.MessageCode]common.Handler
 	interceptors []Interceptor
 	logger       common.Logger
+	config       Config
+	modules      *common.ModuleManager
+	isClosed     bool
+	ctx          context.Context
+	cancel       context.CancelFunc
+	timeouts     map[common.MessageCode]time.Duration
+	timeout      time.Duration
+	connID       int64
+	openConns    int64
+	registry     *metrics.Registry
+	metrics      *serverMetrics
+	httpServers  []*http.Server
+	startedAt    time.Time
+	serving      int32
+	readyChecks  map[string]func() error
+	catalog      []common.CommandSpec
 }
 
 func NewServer(address string) (*Server, error) {
-	listener, err := net.Listen("tcp", address)
+	return NewServerWithConfig(Config{Address: address})
+}
+
+func NewServerWithConfig(config Config) (*Server, error) {
+	if err := common.CheckCodes(); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", config.Address)
 	if err != nil {
 		return nil, err
 	}
-	return &Server{
-		address:      address,
+	if config.TLSConfig != nil {
+		listener = tls.NewListener(listener, config.TLSConfig)
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = common.NewConsoleLogger(common.Info)
+	}
+	s := &Server{
+		address:      config.Address,
+		config:       config,
 		listener:     listener,
 		clientPool:   &sync.Map{},
 		lock:         sync.Mutex{},
 		handlerMap:   make(map[common.MessageCode]common.Handler),
 		interceptors: nil,
-		logger:       common.NewConsoleLogger(common.Debug),
-	}, nil
+		logger:       logger,
+		timeouts:     make(map[common.MessageCode]time.Duration),
+		registry:     metrics.NewRegistry(),
+		startedAt:    time.Now(),
+		readyChecks:  make(map[string]func() error),
+	}
+	s.metrics = newServerMetrics(s.registry)
+	s.registry.NewGaugeFunc("gochat_handlers", "Number of registered message handlers.", func() float64 {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return float64(len(s.handlerMap))
+	})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.modules = common.NewModuleManager(s)
+	return s, nil
 }
 
 func (s *Server) AddHandler(code common.MessageCode, handler common.Handler) {
 	s.lock.Lock()
 	_, ok := s.handlerMap[code]
 	if ok {
-		s.logger.Fatal("duplicate handler")
+		s.logger.Fatal("duplicate handler", common.F("code", code))
 	}
 	s.handlerMap[code] = handler
 	s.lock.Unlock()
@@ -150,70 +310,235 @@ func (s *Server) AddHandler(code common.MessageCode, handler common.Handler) {
 func (s *Server) RemoveHandler(code common.MessageCode) {
 	s.lock.Lock()
 	handler, ok := s.handlerMap[code]
-	if ok {
-		s.logger.Info(fmt.Sprintf("remove handler code=%d", code))
+	if !ok {
+		s.lock.Unlock()
+		return
 	}
+	s.logger.Info("remove handler", common.F("code", code))
 	delete(s.handlerMap, code)
 	s.lock.Unlock()
 	handler.OnRemove(s)
 }
 
+// handler 查找消息码对应的处理器，模块停止时会并发删除处理器，需要加锁读取
+func (s *Server) handler(code common.MessageCode) (common.Handler, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	handler, ok := s.handlerMap[code]
+	return handler, ok
+}
+
+// handlers 返回当前处理器的快照，遍历快照避免与RemoveHandler并发读写map
+func (s *Server) handlers() []common.Handler {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	handlers := make([]common.Handler, 0, len(s.handlerMap))
+	for _, handler := range s.handlerMap {
+		handlers = append(handlers, handler)
+	}
+	return handlers
+}
+
 func (s *Server) AddInterceptor(i Interceptor) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.interceptors = append(s.interceptors, i)
 }
 
+// SetHandlerTimeout 设置某个消息码的处理超时，超时后消息级context被取消，<=0表示不限制
+func (s *Server) SetHandlerTimeout(code common.MessageCode, timeout time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.timeouts[code] = timeout
+}
+
+// SetDefaultHandlerTimeout 未单独设置超时的消息码使用该超时，<=0表示不限制
+func (s *Server) SetDefaultHandlerTimeout(timeout time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.timeout = timeout
+}
+
+func (s *Server) handlerTimeout(code common.MessageCode) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if timeout, ok := s.timeouts[code]; ok {
+		return timeout
+	}
+	return s.timeout
+}
+
+// AddModule 添加模块，模块在Serve时按依赖顺序启动
+func (s *Server) AddModule(module common.Module) error {
+	return s.modules.Add(module)
+}
+
+// SetModuleEnabled 在Serve之前调用，停用的模块及其handler不会被加载
+func (s *Server) SetModuleEnabled(name string, enabled bool) {
+	s.modules.SetEnabled(name, enabled)
+}
+
+func (s *Server) Modules() []string {
+	return s.modules.Names()
+}
+
+// buildCatalog 收集已启动模块公布的命令，命令名和别名不能重复
+func (s *Server) buildCatalog(modules []common.Module) error {
+	var catalog []common.CommandSpec
+	names := make(map[string]string)
+	for _, module := range modules {
+		for _, spec := range module.CommandSpecs() {
+			if err := spec.Validate(); err != nil {
+				return fmt.Errorf("module %s: %w", module.Name(), err)
+			}
+			for _, name := range append([]string{spec.Name}, spec.Alias...) {
+				if owner, ok := names[name]; ok {
+					return fmt.Errorf("command %s of module %s conflicts with module %s", name, module.Name(), owner)
+				}
+				names[name] = module.Name()
+			}
+			catalog = append(catalog, spec)
+		}
+	}
+	common.SortCommands(catalog)
+	s.lock.Lock()
+	s.catalog = catalog
+	s.lock.Unlock()
+	return nil
+}
+
+// Commands 实现common.CommandCatalog
+func (s *Server) Commands() []common.CommandSpec {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]common.CommandSpec(nil), s.catalog...)
+}
+
 func (s *Server) Serve() {
-	s.logger.Info(fmt.Sprintf("server start serve, bind address=%s", s.address))
+	modules, err := s.modules.Start()
+	if err != nil {
+		s.logger.Fatal("start modules error", common.Err(err))
+	}
+	for _, module := range modules {
+		s.logger.Info("module started", common.F("module", module.Name()))
+	}
+	if err := s.buildCatalog(modules); err != nil {
+		s.logger.Fatal("build command catalog error", common.Err(err))
+	}
+	if s.config.MetricsAddress != "" {
+		s.StartHTTPServer("metrics", s.config.MetricsAddress, s.MetricsHandler())
+	}
+	if s.config.DebugAddress != "" {
+		s.StartHTTPServer("debug", s.config.DebugAddress, s.DebugHandler())
+	}
+	s.logger.Info("server start serve", common.F("address", s.address), common.F("tls", s.config.TLSConfig != nil))
+	atomic.StoreInt32(&s.serving, 1)
+	defer atomic.StoreInt32(&s.serving, 0)
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
-			err = s.listener.Close()
-			s.logger.Fatal(err)
+			if s.isClosed {
+				s.logger.Info("server is closed, stop serve")
+				return
+			}
+			_ = s.listener.Close()
+			s.logger.Fatal("accept error", common.Err(err))
+		}
+		s.logger.Debug("new remote client connecting", common.F("remote", conn.RemoteAddr().String()))
+		if s.config.MaxConnections > 0 && atomic.LoadInt64(&s.openConns) >= int64(s.config.MaxConnections) {
+			s.metrics.connectionsRejected.WithLabelValues("limit").Inc()
+			s.logger.Info("too many connections, reject", common.F("remote", conn.RemoteAddr().String()))
+			_ = conn.Close()
+			continue
 		}
-		s.logger.Info("new remote client connecting " + conn.RemoteAddr().String())
-		go s.handleConn(conn)
+		atomic.AddInt64(&s.openConns, 1)
+		s.metrics.connectionsOpen.Inc()
+		go s.handleConn(&countingConn{Conn: conn, metrics: s.metrics})
+	}
+}
+
+// Close 停止接受新连接并停止所有模块
+func (s *Server) Close() error {
+	s.lock.Lock()
+	if s.isClosed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.isClosed = true
+	s.lock.Unlock()
+	if s.config.ShutdownDelay > 0 {
+		s.logger.Info("server is shutting down", common.F("delay", s.config.ShutdownDelay))
+		time.Sleep(s.config.ShutdownDelay)
+	}
+	s.cancel()
+	err := s.listener.Close()
+	for _, e := range s.modules.Stop() {
+		s.logger.Error("stop module error", common.Err(e))
 	}
+	s.shutdownHTTP()
+	return err
+}
+
+// Logger 返回服务端的根logger
+func (s *Server) Logger() common.Logger {
+	return s.logger
 }
 
 func (s *Server) handleConn(conn net.Conn) {
 	var ctx *ServerContext
 	defer func() {
+		atomic.AddInt64(&s.openConns, -1)
+		s.metrics.connectionsOpen.Dec()
 		if err := recover(); err != nil {
-			s.logger.Error(fmt.Sprintf("[panic], err=%s, remote address=%s, stack=[%s]",
-				err, conn.RemoteAddr(), string(debug.Stack())))
+			s.logger.Error("[panic] handle connection error", common.F("panic", err),
+				common.F("remote", conn.RemoteAddr().String()), common.F("stack", string(debug.Stack())))
 		}
 		if ctx != nil {
 			_ = ctx.Close()
 			s.clientPool.Delete(ctx.RemoteAddr())
-			for _, handler := range s.handlerMap {
+			for _, handler := range s.handlers() {
 				handler.OnClose(ctx)
 			}
 		} else {
 			_ = conn.Close()
 		}
 	}()
-	header, err := common.ReadHeader(conn)
+	var principal *common.Principal
+	header, err := common.ServerHandshake(conn, s.config.HandshakeTimeout, func(header *common.Header) (err error) {
+		if err := s.verifyHeader(header); err != nil {
+			return err
+		}
+		principal, err = s.authenticateHandshake(conn, header)
+		return err
+	})
 	if err != nil {
-		s.logger.Error("read header error", err)
-		return
-	}
-	if err = header.Validate(); err != nil {
-		s.logger.Error("validate header error", err)
+		s.metrics.connectionsRejected.WithLabelValues("handshake").Inc()
+		s.logger.Error("handshake error", common.F("remote", conn.RemoteAddr().String()), common.Err(err))
 		return
 	}
+	s.metrics.connectionsAccepted.Inc()
 	codec, err := common.GetCodec(int8(header.CodecType), conn)
 	if err != nil {
-		s.logger.Error("get codec error", err)
+		s.logger.Error("get codec error", common.F("remote", conn.RemoteAddr().String()), common.Err(err))
 		return
 	}
-	s.logger.Info(fmt.Sprintf("connecting completed, remote address=%s", conn.RemoteAddr()))
 	ctx = &ServerContext{
+		id:         atomic.AddInt64(&s.connID, 1),
 		remoteAddr: conn.RemoteAddr().String(),
 		localAddr:  conn.LocalAddr().String(),
 		env:        s,
+		openedAt:   time.Now(),
+		principal:  principal,
 	}
+	ctx.logger = s.logger.With(common.F("conn", ctx.id), common.F("remote", ctx.remoteAddr))
+	ctx.Logger().Info("connecting completed")
+	if principal != nil {
+		ctx.Logger().Info("authenticated", common.F("principal", principal.Name), common.F("method", principal.Method))
+	}
+	ctx.ctx, ctx.cancel = context.WithCancel(s.ctx)
+	// 给连接相关的goroutine打上标签，便于在goroutine dump中定位泄漏
+	ctx.ctx = pprof.WithLabels(ctx.ctx, pprof.Labels("conn", strconv.FormatInt(ctx.id, 10)))
+	pprof.SetGoroutineLabels(ctx.ctx)
 	ch := &ChannelWrapper{
 		Channel:       common.NewSimpleChannel(codec, conn),
 		ServerContext: ctx,
@@ -222,23 +547,37 @@ func (s *Server) handleConn(conn net.Conn) {
 	ctx.Channel = ch
 
 	s.clientPool.Store(ctx.RemoteAddr(), ctx)
-	for _, handler := range s.handlerMap {
+	for _, handler := range s.handlers() {
 		handler.OnActive(ctx)
 	}
 	for {
 		message, err := ctx.Read()
 		if err != nil {
-			s.logger.Error(err)
+			if err == io.EOF || ctx.isClosed {
+				ctx.Logger().Info("connection closed")
+			} else {
+				ctx.Logger().Error("read message error", common.Err(err))
+			}
 			break
 		}
-		handler, ok := s.handlerMap[message.Code]
+		handler, ok := s.handler(message.Code)
 		if !ok {
-			s.logger.Info(fmt.Sprintf("not found matchable handler, remote address=%s, code=%d",
-				ctx.RemoteAddr(), message.Code))
+			// 消息码来自客户端，未处理的都计入unknown，避免标签数量无限增长
+			s.metrics.messagesIn.WithLabelValues("unknown").Inc()
+			ctx.Logger().Info("not found matchable handler", common.F("code", message.Code))
+			_ = ctx.Write(common.NewErrorMessage(message.RequestID, unhandledCodeError(message.Code)))
 			break
 		}
-		if err = SafelyDo(handler, ctx, message); err != nil {
-			s.logger.Error(err)
+		s.metrics.messagesIn.WithLabelValues(message.Code.String()).Inc()
+		if err = s.authenticate(ctx, message); err == nil {
+			err = s.authorize(ctx, message)
+		}
+		if err != nil {
+			s.replyError(ctx, message, err)
+			continue
+		}
+		if err = s.dispatch(handler, ctx, message); err != nil {
+			s.replyError(ctx, message, err)
 		}
 		if ctx.isClosed {
 			break
@@ -246,11 +585,68 @@ func (s *Server) handleConn(conn net.Conn) {
 	}
 }
 
+func (s *Server) dispatch(handler common.Handler, ctx *ServerContext, message *common.RawMessage) error {
+	msgCtx := &messageContext{ServerContext: ctx, message: message}
+	var cancel context.CancelFunc
+	if timeout := s.handlerTimeout(message.Code); timeout > 0 {
+		msgCtx.ctx, cancel = context.WithTimeout(ctx.ctx, timeout)
+	} else {
+		msgCtx.ctx, cancel = context.WithCancel(ctx.ctx)
+	}
+	defer cancel()
+	defer s.metrics.observeHandler(message.Code, time.Now())
+	return SafelyDo(handler, msgCtx, message)
+}
+
+func (s *Server) verifyHeader(header *common.Header) error {
+	if s.config.VerifyToken == nil {
+		return nil
+	}
+	return s.config.VerifyToken(header.Token)
+}
+
+// replyError 把handler返回的*common.CodeError回复给请求方，其余错误只记录日志
+func (s *Server) replyError(ctx *ServerContext, message *common.RawMessage, err error) {
+	logger := ctx.Logger().With(common.F("code", message.Code), common.F("request", message.RequestID))
+	e, ok := common.AsCodeError(err)
+	if !ok {
+		logger.Error("handle message error", common.Err(err))
+		return
+	}
+	logger.Info("handler rejected request", common.F("err_code", e.Code), common.F("err_message", e.Message))
+	if ctx.isClosed {
+		return
+	}
+	if err := ctx.Write(common.NewErrorMessage(message.RequestID, e)); err != nil {
+		logger.Error("reply error message failed", common.Err(err))
+	}
+}
+
+func unhandledCodeError(code common.MessageCode) *common.CodeError {
+	if _, ok := common.LookupCode(code); ok {
+		return common.CodeErrorf(common.ErrCodeNotFound, "message %s is not handled by server", code)
+	}
+	return common.CodeErrorf(common.ErrCodeNotFound, "unknown message code %d", code)
+}
+
+// SafelyDo 执行handler并捕获panic，handler超过消息级context的deadline时返回错误
 func SafelyDo(handler common.Handler, ctx common.Context, message *common.RawMessage) (err error) {
+	start := time.Now()
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("[panic], err=%s, remote address=%s, stack=%s",
-				err, ctx.RemoteAddr(), string(debug.Stack()))
+				e, ctx.RemoteAddr(), string(debug.Stack()))
+			return
+		}
+		if ctx.Context().Err() != context.DeadlineExceeded {
+			return
+		}
+		deadlineErr := fmt.Errorf("handler exceeded deadline, code=%s, remote address=%s, elapsed=%s",
+			message.Code, ctx.RemoteAddr(), time.Since(start))
+		if err != nil {
+			err = fmt.Errorf("%s: %w", deadlineErr, err)
+		} else {
+			err = deadlineErr
 		}
 	}()
 	return handler.OnMessage(ctx, message)
@@ -0,0 +1,172 @@
+package goserver
+
+import (
+	"context"
+	"gochat/common"
+	"gochat/goserver/metrics"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// serverMetrics 服务端内置的指标
+type serverMetrics struct {
+	connectionsOpen     *metrics.Gauge
+	connectionsAccepted *metrics.Counter
+	connectionsRejected *metrics.CounterVec
+	authFailures        *metrics.CounterVec
+	messagesIn          *metrics.CounterVec
+	messagesOut         *metrics.CounterVec
+	bytesIn             *metrics.Counter
+	bytesOut            *metrics.Counter
+	handlerDuration     *metrics.HistogramVec
+	writesInFlight      *metrics.Gauge
+}
+
+func newServerMetrics(registry *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		connectionsOpen: registry.NewGauge("gochat_connections_open",
+			"Number of currently open connections."),
+		connectionsAccepted: registry.NewCounter("gochat_connections_accepted_total",
+			"Total number of connections that completed the handshake."),
+		connectionsRejected: registry.NewCounterVec("gochat_connections_rejected_total",
+			"Total number of rejected connections.", "reason"),
+		authFailures: registry.NewCounterVec("gochat_auth_failures_total",
+			"Total number of failed authentications.", "stage"),
+		messagesIn: registry.NewCounterVec("gochat_messages_in_total",
+			"Total number of messages received.", "code"),
+		messagesOut: registry.NewCounterVec("gochat_messages_out_total",
+			"Total number of messages sent.", "code"),
+		bytesIn: registry.NewCounter("gochat_bytes_in_total",
+			"Total number of bytes received."),
+		bytesOut: registry.NewCounter("gochat_bytes_out_total",
+			"Total number of bytes sent."),
+		handlerDuration: registry.NewHistogramVec("gochat_handler_duration_seconds",
+			"Time spent in message handlers.", nil, "code"),
+		writesInFlight: registry.NewGauge("gochat_outbound_writes_in_flight",
+			"Number of outbound writes in progress, including writes blocked on slow connections."),
+	}
+}
+
+func (m *serverMetrics) observeHandler(code common.MessageCode, start time.Time) {
+	m.handlerDuration.WithLabelValues(code.String()).Observe(time.Since(start).Seconds())
+}
+
+// countingConn 统计连接上读写的字节数
+type countingConn struct {
+	net.Conn
+	metrics *serverMetrics
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.metrics.bytesIn.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.metrics.bytesOut.Add(float64(n))
+	return n, err
+}
+
+// Metrics 返回服务端的指标注册表，业务可以在上面注册自己的指标
+func (s *Server) Metrics() *metrics.Registry {
+	return s.registry
+}
+
+func (s *Server) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.registry.Handler())
+	return mux
+}
+
+// OpenConnections 当前打开的连接数，包含握手中的连接
+func (s *Server) OpenConnections() int64 {
+	return atomic.LoadInt64(&s.openConns)
+}
+
+// StartHTTPServer 在后台启动一个HTTP服务，随Close一起关闭
+func (s *Server) StartHTTPServer(name, address string, handler http.Handler) {
+	server := &http.Server{Addr: address, Handler: handler}
+	s.lock.Lock()
+	s.httpServers = append(s.httpServers, server)
+	s.lock.Unlock()
+	s.logger.Info("http server start serve", common.F("name", name), common.F("address", address))
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("http server error", common.F("name", name), common.Err(err))
+		}
+	}()
+}
+
+func (s *Server) shutdownHTTP() {
+	s.lock.Lock()
+	servers := s.httpServers
+	s.httpServers = nil
+	s.lock.Unlock()
+	for _, server := range servers {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		_ = server.Shutdown(ctx)
+		cancel()
+	}
+}
+
+type ConnectionInfo struct {
+	ID         int64     `json:"id"`
+	RemoteAddr string    `json:"remote_addr"`
+	LocalAddr  string    `json:"local_addr"`
+	OpenedAt   time.Time `json:"opened_at"`
+	Uptime     string    `json:"uptime"`
+	// Principal 已认证连接的身份名
+	Principal string `json:"principal,omitempty"`
+}
+
+// Connections 返回已完成握手的连接
+func (s *Server) Connections() []ConnectionInfo {
+	infos := make([]ConnectionInfo, 0)
+	s.clientPool.Range(func(_, value interface{}) bool {
+		ctx := value.(*ServerContext)
+		info := ConnectionInfo{
+			ID:         ctx.ID(),
+			RemoteAddr: ctx.RemoteAddr(),
+			LocalAddr:  ctx.LocalAddr(),
+			OpenedAt:   ctx.OpenedAt(),
+			Uptime:     time.Since(ctx.OpenedAt()).Truncate(time.Second).String(),
+		}
+		if principal := ctx.Principal(); principal != nil {
+			info.Principal = principal.Name
+		}
+		infos = append(infos, info)
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+type Stats struct {
+	StartedAt           time.Time `json:"started_at"`
+	Uptime              string    `json:"uptime"`
+	OpenConnections     int64     `json:"open_connections"`
+	AcceptedConnections int64     `json:"accepted_connections"`
+	Handlers            int       `json:"handlers"`
+	Modules             []string  `json:"modules"`
+}
+
+func (s *Server) Stats() Stats {
+	s.lock.Lock()
+	handlers := len(s.handlerMap)
+	s.lock.Unlock()
+	return Stats{
+		StartedAt:           s.startedAt,
+		Uptime:              time.Since(s.startedAt).Truncate(time.Second).String(),
+		OpenConnections:     s.OpenConnections(),
+		AcceptedConnections: int64(s.metrics.connectionsAccepted.Get()),
+		Handlers:            handlers,
+		Modules:             s.modules.Names(),
+	}
+}